@@ -0,0 +1,247 @@
+package chaincode
+
+import (
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const attestationObjectType = "Attestation"
+
+type Attestation struct {
+    RecordID    string    `json:"recordId"`
+    Type        string    `json:"type"`
+    RecordHash  string    `json:"recordHash"`
+    Signature   string    `json:"signature"`
+    AttesterMSP string    `json:"attesterMsp"`
+    AttesterID  string    `json:"attesterId"`
+    AttestedAt  time.Time `json:"attestedAt"`
+}
+
+// AttestRecord records that the caller vouches for the current state of a crop record.
+// The signature is made with the caller's enrollment key over the attestation message
+// returned by GetRecordDigest, which covers the record ID, the attestation type and the
+// record as stored, so a signature for one claim cannot be replayed as another. ECDSA
+// keys sign the message directly (ASN.1 encoded), Ed25519 keys sign its 32 bytes.
+// Attesting again under the same type replaces the caller's earlier attestation.
+func (f *SmartContract) AttestRecord(ctx contractapi.TransactionContextInterface, recordID string, attestationType string, signature string) error {
+    if _, err := f.HarvestCrop(ctx, recordID); err != nil {
+        return err
+    }
+    if attestationType == "" {
+        return fmt.Errorf("attestation type must not be empty")
+    }
+
+    digest, err := recordDigest(ctx, recordID)
+    if err != nil {
+        return err
+    }
+    message, err := attestationMessage(recordID, attestationType, digest)
+    if err != nil {
+        return err
+    }
+    signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+    if err != nil {
+        return fmt.Errorf("signature is not valid base64: %v", err)
+    }
+    if err := verifyAttesterSignature(ctx, message, signatureBytes); err != nil {
+        return err
+    }
+
+    attesterMSP, attesterID, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    attestedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    attestation := Attestation{
+        RecordID:    recordID,
+        Type:        attestationType,
+        RecordHash:  hex.EncodeToString(digest),
+        Signature:   signature,
+        AttesterMSP: attesterMSP,
+        AttesterID:  attesterID,
+        AttestedAt:  attestedAt,
+    }
+
+    attestationJSON, err := json.Marshal(attestation)
+    if err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(attestationObjectType, []string{recordID, attestationType, attesterMSP, attesterID})
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, attestationJSON)
+}
+
+// GetRecordDigest returns, hex encoded, the attestation message AttestRecord expects
+// attesters to sign for a claim of attestationType about the crop record as currently stored.
+func (f *SmartContract) GetRecordDigest(ctx contractapi.TransactionContextInterface, recordID string, attestationType string) (string, error) {
+    if _, err := f.HarvestCrop(ctx, recordID); err != nil {
+        return "", err
+    }
+    if attestationType == "" {
+        return "", fmt.Errorf("attestation type must not be empty")
+    }
+
+    digest, err := recordDigest(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+    message, err := attestationMessage(recordID, attestationType, digest)
+    if err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(message), nil
+}
+
+func (f *SmartContract) GetAttestations(ctx contractapi.TransactionContextInterface, recordID string) ([]*Attestation, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(attestationObjectType, []string{recordID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var attestations []*Attestation
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var attestation Attestation
+        err = json.Unmarshal(queryResponse.Value, &attestation)
+        if err != nil {
+            return nil, err
+        }
+        attestations = append(attestations, &attestation)
+    }
+
+    return attestations, nil
+}
+
+// GetRecordsWithAttestations returns the crop records with at least k attestations of
+// the given type. Attestations made against an earlier version of a record no longer
+// match its hash and are not counted.
+func (f *SmartContract) GetRecordsWithAttestations(ctx contractapi.TransactionContextInterface, attestationType string, k int) ([]*CropRecord, error) {
+    if k <= 0 {
+        return nil, fmt.Errorf("k must be positive")
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(attestationObjectType, []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    currentHashes := make(map[string]string)
+    counts := make(map[string]int)
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var attestation Attestation
+        err = json.Unmarshal(queryResponse.Value, &attestation)
+        if err != nil {
+            return nil, err
+        }
+        if attestation.Type != attestationType {
+            continue
+        }
+
+        currentHash, ok := currentHashes[attestation.RecordID]
+        if !ok {
+            digest, err := recordDigest(ctx, attestation.RecordID)
+            if err != nil {
+                return nil, err
+            }
+            currentHash = hex.EncodeToString(digest)
+            currentHashes[attestation.RecordID] = currentHash
+        }
+        if attestation.RecordHash == currentHash {
+            counts[attestation.RecordID]++
+        }
+    }
+
+    var ids []string
+    for id, count := range counts {
+        if count >= k {
+            ids = append(ids, id)
+        }
+    }
+    sort.Strings(ids)
+
+    var crops []*CropRecord
+    for _, id := range ids {
+        crop, err := f.readCrop(ctx, id)
+        if err != nil {
+            return nil, err
+        }
+        if crop.Deleted == nil {
+            crops = append(crops, crop)
+        }
+    }
+
+    return crops, nil
+}
+
+// recordDigest hashes the record exactly as stored, so attesters can compute it from
+// the bytes returned by a state query. A missing record hashes to nothing.
+func recordDigest(ctx contractapi.TransactionContextInterface, recordID string) ([]byte, error) {
+    recordJSON, err := ctx.GetStub().GetState(recordID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read crop record from world state: %v", err)
+    }
+    if recordJSON == nil {
+        return nil, nil
+    }
+    digest := sha256.Sum256(recordJSON)
+    return digest[:], nil
+}
+
+// attestationMessage is the SHA-256 digest of the record ID, the attestation type and
+// the record digest, JSON encoded as one array so that no two claims share an encoding.
+func attestationMessage(recordID string, attestationType string, digest []byte) ([]byte, error) {
+    claim, err := json.Marshal([]string{recordID, attestationType, hex.EncodeToString(digest)})
+    if err != nil {
+        return nil, err
+    }
+    message := sha256.Sum256(claim)
+    return message[:], nil
+}
+
+func verifyAttesterSignature(ctx contractapi.TransactionContextInterface, message []byte, signature []byte) error {
+    cert, err := ctx.GetClientIdentity().GetX509Certificate()
+    if err != nil {
+        return fmt.Errorf("failed to read client certificate: %v", err)
+    }
+
+    switch key := cert.PublicKey.(type) {
+    case *ecdsa.PublicKey:
+        if !ecdsa.VerifyASN1(key, message, signature) {
+            return fmt.Errorf("ECDSA attestation signature verification failed")
+        }
+    case ed25519.PublicKey:
+        if !ed25519.Verify(key, message, signature) {
+            return fmt.Errorf("Ed25519 attestation signature verification failed")
+        }
+    default:
+        return fmt.Errorf("unsupported attester key type %T, expected ECDSA or Ed25519", key)
+    }
+    return nil
+}
@@ -0,0 +1,499 @@
+package chaincode
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    payloadManifestObjectType = "PayloadManifest"
+    payloadUploadObjectType   = "PayloadUpload"
+    payloadChunkObjectType    = "PayloadChunk"
+
+    // maxChunkSize keeps each chunk well inside the state value and gRPC message limits.
+    maxChunkSize = 256 * 1024
+
+    // uploadTimeout is how long an unfinished upload holds a record against other
+    // uploaders. After that anyone may abort it or start a new one.
+    uploadTimeout = 24 * time.Hour
+)
+
+// PayloadManifest describes the finalized payload of a crop record. Its chunks are keyed
+// by UploadID.
+type PayloadManifest struct {
+    RecordID    string    `json:"recordId"`
+    UploadID    string    `json:"uploadId"`
+    Uploader    string    `json:"uploader"`
+    UploaderMSP string    `json:"uploaderMsp"`
+    ChunkCount  int       `json:"chunkCount"`
+    ChunkHashes []string  `json:"chunkHashes,omitempty"`
+    SHA256      string    `json:"sha256,omitempty"`
+    Size        int64     `json:"size"`
+    StartedAt   time.Time `json:"startedAt"`
+    FinalizedAt time.Time `json:"finalizedAt"`
+}
+
+// PayloadUpload is an upload in progress. It is kept apart from the manifest so that
+// the finalized payload stays readable until the new one replaces it.
+type PayloadUpload struct {
+    RecordID    string    `json:"recordId"`
+    UploadID    string    `json:"uploadId"`
+    Uploader    string    `json:"uploader"`
+    UploaderMSP string    `json:"uploaderMsp"`
+    StartedAt   time.Time `json:"startedAt"`
+    ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type PayloadChunk struct {
+    RecordID string `json:"recordId"`
+    UploadID string `json:"uploadId"`
+    Index    int    `json:"index"`
+    Data     []byte `json:"data"`
+    SHA256   string `json:"sha256"`
+}
+
+// BeginPayloadUpload opens a chunked upload for a crop record and returns its ID. Chunks
+// are written by separate transactions so that no single proposal has to carry the whole
+// payload, and only finalization makes the new payload visible. Once a record has a
+// payload, only its uploader or an admin can replace it.
+func (f *SmartContract) BeginPayloadUpload(ctx contractapi.TransactionContextInterface, recordID string) (string, error) {
+    if _, err := f.HarvestCrop(ctx, recordID); err != nil {
+        return "", err
+    }
+
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return "", err
+    }
+    startedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    manifest, err := f.readPayloadManifest(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+    if manifest != nil && (manifest.UploaderMSP != mspID || manifest.Uploader != clientID) {
+        if err := requireAdmin(ctx); err != nil {
+            return "", fmt.Errorf("only the uploader of the payload of crop record %s or an admin can replace it", recordID)
+        }
+    }
+
+    pending, err := f.readPayloadUpload(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+    if pending != nil {
+        if startedAt.Before(pending.ExpiresAt) && (pending.UploaderMSP != mspID || pending.Uploader != clientID) {
+            return "", fmt.Errorf("an upload for crop record %s is already in progress by another client", recordID)
+        }
+        if err := f.deleteUploadChunks(ctx, recordID, pending.UploadID, 0); err != nil {
+            return "", err
+        }
+    }
+
+    upload := &PayloadUpload{
+        RecordID:    recordID,
+        UploadID:    ctx.GetStub().GetTxID(),
+        Uploader:    clientID,
+        UploaderMSP: mspID,
+        StartedAt:   startedAt,
+        ExpiresAt:   startedAt.Add(uploadTimeout),
+    }
+    if err := f.putPayloadUpload(ctx, upload); err != nil {
+        return "", err
+    }
+
+    return upload.UploadID, nil
+}
+
+func (f *SmartContract) UploadPayloadChunk(ctx contractapi.TransactionContextInterface, recordID string, index int, chunk string) error {
+    upload, err := f.openUpload(ctx, recordID)
+    if err != nil {
+        return err
+    }
+    if index < 0 {
+        return fmt.Errorf("chunk index must not be negative")
+    }
+
+    data, err := base64.StdEncoding.DecodeString(chunk)
+    if err != nil {
+        return fmt.Errorf("chunk %d is not valid base64: %v", index, err)
+    }
+    if len(data) == 0 || len(data) > maxChunkSize {
+        return fmt.Errorf("chunk %d is %d bytes, expected 1 to %d", index, len(data), maxChunkSize)
+    }
+
+    digest := sha256.Sum256(data)
+    payloadChunk := PayloadChunk{
+        RecordID: upload.RecordID,
+        UploadID: upload.UploadID,
+        Index:    index,
+        Data:     data,
+        SHA256:   hex.EncodeToString(digest[:]),
+    }
+
+    chunkJSON, err := json.Marshal(payloadChunk)
+    if err != nil {
+        return err
+    }
+    key, err := payloadChunkKey(ctx, recordID, upload.UploadID, index)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, chunkJSON)
+}
+
+// FinalizePayloadUpload checks that chunks 0..chunkCount-1 are present and that their
+// concatenation hashes to the SHA-256 the client computed before uploading. The new
+// manifest replaces the previous payload, whose chunks are deleted in the same
+// transaction.
+func (f *SmartContract) FinalizePayloadUpload(ctx contractapi.TransactionContextInterface, recordID string, chunkCount int, sha256Hex string) (*PayloadManifest, error) {
+    upload, err := f.openUpload(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+    if chunkCount <= 0 {
+        return nil, fmt.Errorf("chunk count must be positive")
+    }
+
+    overall := sha256.New()
+    hashes := make([]string, 0, chunkCount)
+    var size int64
+    for index := 0; index < chunkCount; index++ {
+        payloadChunk, err := f.readPayloadChunk(ctx, recordID, upload.UploadID, index)
+        if err != nil {
+            return nil, err
+        }
+        overall.Write(payloadChunk.Data)
+        hashes = append(hashes, payloadChunk.SHA256)
+        size += int64(len(payloadChunk.Data))
+    }
+
+    actual := hex.EncodeToString(overall.Sum(nil))
+    if actual != sha256Hex {
+        return nil, fmt.Errorf("payload for crop record %s hashes to %s, expected %s", recordID, actual, sha256Hex)
+    }
+
+    if err := f.deleteUploadChunks(ctx, recordID, upload.UploadID, chunkCount); err != nil {
+        return nil, err
+    }
+    previous, err := f.readPayloadManifest(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+    if previous != nil {
+        if err := f.deleteUploadChunks(ctx, recordID, previous.UploadID, 0); err != nil {
+            return nil, err
+        }
+    }
+    if err := f.deletePayloadUpload(ctx, recordID); err != nil {
+        return nil, err
+    }
+
+    finalizedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+    manifest := &PayloadManifest{
+        RecordID:    recordID,
+        UploadID:    upload.UploadID,
+        Uploader:    upload.Uploader,
+        UploaderMSP: upload.UploaderMSP,
+        ChunkCount:  chunkCount,
+        ChunkHashes: hashes,
+        SHA256:      actual,
+        Size:        size,
+        StartedAt:   upload.StartedAt,
+        FinalizedAt: finalizedAt,
+    }
+    if err := f.putPayloadManifest(ctx, manifest); err != nil {
+        return nil, err
+    }
+
+    return manifest, nil
+}
+
+// AbortPayloadUpload discards an unfinished upload and its chunks. The uploader and
+// admins can abort at any time, anyone else only once the upload has expired.
+func (f *SmartContract) AbortPayloadUpload(ctx contractapi.TransactionContextInterface, recordID string) error {
+    upload, err := f.readPayloadUpload(ctx, recordID)
+    if err != nil {
+        return err
+    }
+    if upload == nil {
+        return fmt.Errorf("no upload is in progress for crop record %s", recordID)
+    }
+
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if now.Before(upload.ExpiresAt) && (upload.UploaderMSP != mspID || upload.Uploader != clientID) {
+        if err := requireAdmin(ctx); err != nil {
+            return fmt.Errorf("the upload for crop record %s belongs to another client until %s", recordID, upload.ExpiresAt.Format(time.RFC3339))
+        }
+    }
+
+    if err := f.deleteUploadChunks(ctx, recordID, upload.UploadID, 0); err != nil {
+        return err
+    }
+    return f.deletePayloadUpload(ctx, recordID)
+}
+
+func (f *SmartContract) GetPayloadManifest(ctx contractapi.TransactionContextInterface, recordID string) (*PayloadManifest, error) {
+    manifest, err := f.readPayloadManifest(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+    if manifest == nil {
+        return nil, fmt.Errorf("the crop record %s has no chunked payload", recordID)
+    }
+    return manifest, nil
+}
+
+func (f *SmartContract) GetPayloadUpload(ctx contractapi.TransactionContextInterface, recordID string) (*PayloadUpload, error) {
+    upload, err := f.readPayloadUpload(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+    if upload == nil {
+        return nil, fmt.Errorf("no upload is in progress for crop record %s", recordID)
+    }
+    return upload, nil
+}
+
+// ReadPayloadChunk returns one chunk after checking it against the finalized manifest,
+// for clients that stream payloads too large for a single response.
+func (f *SmartContract) ReadPayloadChunk(ctx contractapi.TransactionContextInterface, recordID string, index int) (string, error) {
+    manifest, err := f.GetPayloadManifest(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+    if index < 0 || index >= manifest.ChunkCount {
+        return "", fmt.Errorf("chunk index %d is out of range for %d chunks", index, manifest.ChunkCount)
+    }
+
+    data, err := f.verifiedChunk(ctx, manifest, index)
+    if err != nil {
+        return "", err
+    }
+
+    return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (f *SmartContract) ReadPayload(ctx contractapi.TransactionContextInterface, recordID string) (string, error) {
+    manifest, err := f.GetPayloadManifest(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+
+    var payload bytes.Buffer
+    for index := 0; index < manifest.ChunkCount; index++ {
+        data, err := f.verifiedChunk(ctx, manifest, index)
+        if err != nil {
+            return "", err
+        }
+        payload.Write(data)
+    }
+
+    digest := sha256.Sum256(payload.Bytes())
+    if hex.EncodeToString(digest[:]) != manifest.SHA256 {
+        return "", fmt.Errorf("payload for crop record %s does not match its manifest hash", recordID)
+    }
+
+    return base64.StdEncoding.EncodeToString(payload.Bytes()), nil
+}
+
+// openUpload returns the caller's unexpired upload for the record.
+func (f *SmartContract) openUpload(ctx contractapi.TransactionContextInterface, recordID string) (*PayloadUpload, error) {
+    upload, err := f.readPayloadUpload(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+    if upload == nil {
+        return nil, fmt.Errorf("no upload is in progress for crop record %s", recordID)
+    }
+
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if upload.UploaderMSP != mspID || upload.Uploader != clientID {
+        return nil, fmt.Errorf("the upload for crop record %s belongs to another client", recordID)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if !now.Before(upload.ExpiresAt) {
+        return nil, fmt.Errorf("the upload for crop record %s expired at %s", recordID, upload.ExpiresAt.Format(time.RFC3339))
+    }
+
+    return upload, nil
+}
+
+func (f *SmartContract) verifiedChunk(ctx contractapi.TransactionContextInterface, manifest *PayloadManifest, index int) ([]byte, error) {
+    payloadChunk, err := f.readPayloadChunk(ctx, manifest.RecordID, manifest.UploadID, index)
+    if err != nil {
+        return nil, err
+    }
+
+    digest := sha256.Sum256(payloadChunk.Data)
+    if hex.EncodeToString(digest[:]) != manifest.ChunkHashes[index] {
+        return nil, fmt.Errorf("chunk %d of crop record %s does not match its manifest hash", index, manifest.RecordID)
+    }
+
+    return payloadChunk.Data, nil
+}
+
+func (f *SmartContract) readPayloadManifest(ctx contractapi.TransactionContextInterface, recordID string) (*PayloadManifest, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(payloadManifestObjectType, []string{recordID})
+    if err != nil {
+        return nil, err
+    }
+    manifestJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read payload manifest from world state: %v", err)
+    }
+    if manifestJSON == nil {
+        return nil, nil
+    }
+
+    var manifest PayloadManifest
+    err = json.Unmarshal(manifestJSON, &manifest)
+    if err != nil {
+        return nil, err
+    }
+
+    return &manifest, nil
+}
+
+func (f *SmartContract) putPayloadManifest(ctx contractapi.TransactionContextInterface, manifest *PayloadManifest) error {
+    key, err := ctx.GetStub().CreateCompositeKey(payloadManifestObjectType, []string{manifest.RecordID})
+    if err != nil {
+        return err
+    }
+    manifestJSON, err := json.Marshal(manifest)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, manifestJSON)
+}
+
+func (f *SmartContract) readPayloadUpload(ctx contractapi.TransactionContextInterface, recordID string) (*PayloadUpload, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(payloadUploadObjectType, []string{recordID})
+    if err != nil {
+        return nil, err
+    }
+    uploadJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read payload upload from world state: %v", err)
+    }
+    if uploadJSON == nil {
+        return nil, nil
+    }
+
+    var upload PayloadUpload
+    err = json.Unmarshal(uploadJSON, &upload)
+    if err != nil {
+        return nil, err
+    }
+
+    return &upload, nil
+}
+
+func (f *SmartContract) putPayloadUpload(ctx contractapi.TransactionContextInterface, upload *PayloadUpload) error {
+    key, err := ctx.GetStub().CreateCompositeKey(payloadUploadObjectType, []string{upload.RecordID})
+    if err != nil {
+        return err
+    }
+    uploadJSON, err := json.Marshal(upload)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, uploadJSON)
+}
+
+func (f *SmartContract) deletePayloadUpload(ctx contractapi.TransactionContextInterface, recordID string) error {
+    key, err := ctx.GetStub().CreateCompositeKey(payloadUploadObjectType, []string{recordID})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func (f *SmartContract) readPayloadChunk(ctx contractapi.TransactionContextInterface, recordID string, uploadID string, index int) (*PayloadChunk, error) {
+    key, err := payloadChunkKey(ctx, recordID, uploadID, index)
+    if err != nil {
+        return nil, err
+    }
+    chunkJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read payload chunk from world state: %v", err)
+    }
+    if chunkJSON == nil {
+        return nil, fmt.Errorf("chunk %d of crop record %s is missing", index, recordID)
+    }
+
+    var payloadChunk PayloadChunk
+    err = json.Unmarshal(chunkJSON, &payloadChunk)
+    if err != nil {
+        return nil, err
+    }
+
+    return &payloadChunk, nil
+}
+
+// deleteUploadChunks removes the chunks of an upload from index first on, which drops
+// chunks uploaded beyond the final count or, from 0, the whole upload.
+func (f *SmartContract) deleteUploadChunks(ctx contractapi.TransactionContextInterface, recordID string, uploadID string, first int) error {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(payloadChunkObjectType, []string{recordID, uploadID})
+    if err != nil {
+        return err
+    }
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+
+        _, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return err
+        }
+        index, err := strconv.Atoi(attributes[2])
+        if err != nil {
+            return fmt.Errorf("malformed chunk key for crop record %s: %v", recordID, err)
+        }
+        if index < first {
+            continue
+        }
+        if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// payloadChunkKey zero-pads the index so chunks are iterated in order.
+func payloadChunkKey(ctx contractapi.TransactionContextInterface, recordID string, uploadID string, index int) (string, error) {
+    return ctx.GetStub().CreateCompositeKey(payloadChunkObjectType, []string{recordID, uploadID, fmt.Sprintf("%06d", index)})
+}
@@ -0,0 +1,215 @@
+package chaincode
+
+import (
+    "encoding/base32"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "math/big"
+    "strings"
+)
+
+const (
+    codecRaw    = 0x55
+    codecDagPB  = 0x70
+    hashSHA2256 = 0x12
+    hashSHA2512 = 0x13
+)
+
+var cidCodecs = map[uint64]string{
+    codecRaw:   "raw",
+    codecDagPB: "dag-pb",
+    0x71:       "dag-cbor",
+    0x0129:     "dag-json",
+    0x0200:     "json",
+}
+
+type multihashAlgorithm struct {
+    name   string
+    length int
+}
+
+var multihashAlgorithms = map[uint64]multihashAlgorithm{
+    hashSHA2256: {"sha2-256", 32},
+    hashSHA2512: {"sha2-512", 64},
+    0x16:        {"sha3-256", 32},
+    0x14:        {"sha3-512", 64},
+    0x1b:        {"keccak-256", 32},
+    0xb220:      {"blake2b-256", 32},
+    0xb260:      {"blake2s-256", 32},
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ContentID is a parsed IPFS-style content identifier. String returns the canonical
+// form: base58btc for CIDv0 and lower-case base32 for CIDv1.
+type ContentID struct {
+    Version       int
+    Codec         uint64
+    HashCode      uint64
+    HashAlgorithm string
+    Digest        []byte
+}
+
+func (c *ContentID) CodecName() string {
+    return cidCodecs[c.Codec]
+}
+
+func (c *ContentID) String() string {
+    multihash := appendUvarint(nil, c.HashCode)
+    multihash = appendUvarint(multihash, uint64(len(c.Digest)))
+    multihash = append(multihash, c.Digest...)
+
+    if c.Version == 0 {
+        return encodeBase58(multihash)
+    }
+
+    raw := appendUvarint(nil, uint64(c.Version))
+    raw = appendUvarint(raw, c.Codec)
+    raw = append(raw, multihash...)
+    return "b" + base32Lower.EncodeToString(raw)
+}
+
+// ParseCID validates a CIDv0 or CIDv1 string entirely offline: multibase, version,
+// codec, multihash algorithm and digest length are all checked.
+func ParseCID(text string) (*ContentID, error) {
+    if len(text) == 46 && strings.HasPrefix(text, "Qm") {
+        raw, err := decodeBase58(text)
+        if err != nil {
+            return nil, fmt.Errorf("invalid CIDv0 %q: %v", text, err)
+        }
+        cid := &ContentID{Version: 0, Codec: codecDagPB}
+        if err := cid.parseMultihash(raw); err != nil {
+            return nil, fmt.Errorf("invalid CIDv0 %q: %v", text, err)
+        }
+        if cid.HashCode != hashSHA2256 {
+            return nil, fmt.Errorf("invalid CIDv0 %q: CIDv0 must use sha2-256", text)
+        }
+        return cid, nil
+    }
+
+    if len(text) < 2 {
+        return nil, fmt.Errorf("invalid CID %q: too short", text)
+    }
+    raw, err := decodeMultibase(text)
+    if err != nil {
+        return nil, fmt.Errorf("invalid CID %q: %v", text, err)
+    }
+
+    version, n := binary.Uvarint(raw)
+    if n <= 0 || version != 1 {
+        return nil, fmt.Errorf("invalid CID %q: unsupported version", text)
+    }
+    raw = raw[n:]
+
+    codec, n := binary.Uvarint(raw)
+    if n <= 0 {
+        return nil, fmt.Errorf("invalid CID %q: malformed codec", text)
+    }
+    if _, ok := cidCodecs[codec]; !ok {
+        return nil, fmt.Errorf("invalid CID %q: unsupported codec 0x%x", text, codec)
+    }
+
+    cid := &ContentID{Version: 1, Codec: codec}
+    if err := cid.parseMultihash(raw[n:]); err != nil {
+        return nil, fmt.Errorf("invalid CID %q: %v", text, err)
+    }
+    return cid, nil
+}
+
+func (c *ContentID) parseMultihash(raw []byte) error {
+    code, n := binary.Uvarint(raw)
+    if n <= 0 {
+        return fmt.Errorf("malformed multihash code")
+    }
+    raw = raw[n:]
+
+    algorithm, ok := multihashAlgorithms[code]
+    if !ok {
+        return fmt.Errorf("unsupported multihash algorithm 0x%x", code)
+    }
+
+    length, n := binary.Uvarint(raw)
+    if n <= 0 {
+        return fmt.Errorf("malformed multihash length")
+    }
+    digest := raw[n:]
+    if length != uint64(algorithm.length) {
+        return fmt.Errorf("%s digests are %d bytes, multihash declares %d", algorithm.name, algorithm.length, length)
+    }
+    if len(digest) != algorithm.length {
+        return fmt.Errorf("%s digest is %d bytes, expected %d", algorithm.name, len(digest), algorithm.length)
+    }
+
+    c.HashCode = code
+    c.HashAlgorithm = algorithm.name
+    c.Digest = digest
+    return nil
+}
+
+func decodeMultibase(text string) ([]byte, error) {
+    prefix, body := text[0], text[1:]
+    switch prefix {
+    case 'b':
+        return base32Lower.DecodeString(body)
+    case 'B':
+        return base32Lower.DecodeString(strings.ToLower(body))
+    case 'z':
+        return decodeBase58(body)
+    case 'f', 'F':
+        return hex.DecodeString(body)
+    default:
+        return nil, fmt.Errorf("unsupported multibase prefix %q", prefix)
+    }
+}
+
+func decodeBase58(text string) ([]byte, error) {
+    value := new(big.Int)
+    radix := big.NewInt(58)
+    for _, r := range text {
+        digit := strings.IndexRune(base58Alphabet, r)
+        if digit < 0 {
+            return nil, fmt.Errorf("invalid base58 character %q", r)
+        }
+        value.Mul(value, radix)
+        value.Add(value, big.NewInt(int64(digit)))
+    }
+
+    decoded := value.Bytes()
+    leadingZeros := 0
+    for leadingZeros < len(text) && text[leadingZeros] == base58Alphabet[0] {
+        leadingZeros++
+    }
+    return append(make([]byte, leadingZeros), decoded...), nil
+}
+
+func encodeBase58(data []byte) string {
+    value := new(big.Int).SetBytes(data)
+    radix := big.NewInt(58)
+    mod := new(big.Int)
+
+    var encoded []byte
+    for value.Sign() > 0 {
+        value.DivMod(value, radix, mod)
+        encoded = append(encoded, base58Alphabet[mod.Int64()])
+    }
+    for _, b := range data {
+        if b != 0 {
+            break
+        }
+        encoded = append(encoded, base58Alphabet[0])
+    }
+
+    for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+        encoded[i], encoded[j] = encoded[j], encoded[i]
+    }
+    return string(encoded)
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+    var tmp [binary.MaxVarintLen64]byte
+    n := binary.PutUvarint(tmp[:], value)
+    return append(buf, tmp[:n]...)
+}
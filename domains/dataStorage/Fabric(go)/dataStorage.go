@@ -15,9 +15,28 @@ type SmartContract struct {
 }
 
 type CropRecord struct {
-    ID        string `json:"id"`        
-    Data      string `json:"data"`      
-    Timestamp string `json:"timestamp"` 
+    ID            string        `json:"id"`
+    Data          string        `json:"data"`
+    Timestamp     string        `json:"timestamp"`
+    DataHash      string        `json:"dataHash,omitempty"`
+    Collection    string        `json:"collection,omitempty"`
+    SchemaID      string        `json:"schemaId,omitempty"`
+    SchemaVersion uint64        `json:"schemaVersion,omitempty"`
+    Documents     []DocumentRef `json:"documents,omitempty"`
+    PersonalHash  string        `json:"personalDataHash,omitempty"`
+    ErasureID     string        `json:"erasureId,omitempty"`
+    Version       uint64        `json:"version"`
+    Deleted       *Tombstone    `json:"deleted,omitempty"`
+}
+
+type VersionConflictError struct {
+    ID       string
+    Expected uint64
+    Actual   uint64
+}
+
+func (e *VersionConflictError) Error() string {
+    return fmt.Sprintf("version conflict on crop record %s: expected version %d but found %d", e.ID, e.Expected, e.Actual)
 }
 
 type CropHistoryQueryResult struct {
@@ -33,11 +52,13 @@ func (f *SmartContract) InitFarm(ctx contractapi.TransactionContextInterface) er
             ID:        "Crop1",
             Data:      "Initial Crop Data 1",
             Timestamp: time.Now().String(),
+            Version:   1,
         },
         {
             ID:        "Crop2",
             Data:      "Initial Crop Data 2",
             Timestamp: time.Now().String(),
+            Version:   1,
         },
     }
 
@@ -56,7 +77,7 @@ func (f *SmartContract) InitFarm(ctx contractapi.TransactionContextInterface) er
     return nil
 }
 
-func (f *SmartContract) PlantCrop(ctx contractapi.TransactionContextInterface, id string, data string) error {
+func (f *SmartContract) PlantCrop(ctx contractapi.TransactionContextInterface, id string, data string, schemaID string, schemaVersion uint64) error {
     exists, err := f.CropExists(ctx, id)
     if err != nil {
         return err
@@ -69,6 +90,12 @@ func (f *SmartContract) PlantCrop(ctx contractapi.TransactionContextInterface, i
         ID:        id,
         Data:      data,
         Timestamp: time.Now().String(),
+        Version:   1,
+    }
+
+    err = f.storePayload(ctx, &crop, schemaID, schemaVersion)
+    if err != nil {
+        return err
     }
 
     cropJSON, err := json.Marshal(crop)
@@ -79,19 +106,43 @@ func (f *SmartContract) PlantCrop(ctx contractapi.TransactionContextInterface, i
     return ctx.GetStub().PutState(id, cropJSON)
 }
 
-func (f *SmartContract) UpdateCrop(ctx contractapi.TransactionContextInterface, id string, data string) error {
-    exists, err := f.CropExists(ctx, id)
+func (f *SmartContract) UpdateCrop(ctx contractapi.TransactionContextInterface, id string, data string, schemaID string, schemaVersion uint64, expectedVersion uint64) error {
+    existing, err := f.HarvestCrop(ctx, id)
     if err != nil {
         return err
     }
-    if !exists {
-        return fmt.Errorf("the crop record %s does not exist", id)
+    if existing.Version != expectedVersion {
+        return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: existing.Version}
+    }
+
+    // A record created under a schema stays under it. An update that names no schema is
+    // validated against the record's schema and version.
+    if existing.SchemaID != "" {
+        if schemaID == "" {
+            schemaID = existing.SchemaID
+            if schemaVersion == 0 {
+                schemaVersion = existing.SchemaVersion
+            }
+        } else if schemaID != existing.SchemaID {
+            return fmt.Errorf("the crop record %s is governed by schema %s, not %s", id, existing.SchemaID, schemaID)
+        }
     }
 
     crop := CropRecord{
-        ID:        id,
-        Data:      data,
-        Timestamp: time.Now().String(),
+        ID:           id,
+        Data:         data,
+        Timestamp:    time.Now().String(),
+        DataHash:     existing.DataHash,
+        Collection:   existing.Collection,
+        Documents:    existing.Documents,
+        PersonalHash: existing.PersonalHash,
+        ErasureID:    existing.ErasureID,
+        Version:      existing.Version + 1,
+    }
+
+    err = f.storePayload(ctx, &crop, schemaID, schemaVersion)
+    if err != nil {
+        return err
     }
 
     cropJSON, err := json.Marshal(crop)
@@ -102,7 +153,51 @@ func (f *SmartContract) UpdateCrop(ctx contractapi.TransactionContextInterface,
     return ctx.GetStub().PutState(id, cropJSON)
 }
 
+// storePayload validates the record's payload against the referenced schema, if any, and
+// moves a transient payload into the private collection. When a transient payload is
+// supplied it is the one validated; otherwise the public data is.
+func (f *SmartContract) storePayload(ctx contractapi.TransactionContextInterface, crop *CropRecord, schemaID string, schemaVersion uint64) error {
+    payload, salt, private, err := readTransientPayload(ctx)
+    if err != nil {
+        return err
+    }
+
+    if schemaID != "" {
+        document := []byte(crop.Data)
+        if private {
+            document = payload
+        }
+        crop.SchemaVersion, err = f.validatePayload(ctx, schemaID, schemaVersion, document)
+        if err != nil {
+            return err
+        }
+        crop.SchemaID = schemaID
+    }
+
+    if private {
+        crop.DataHash, err = putPrivatePayload(ctx, crop.ID, payload, salt)
+        if err != nil {
+            return err
+        }
+        crop.Collection = cropPrivateCollection
+    }
+
+    return nil
+}
+
 func (f *SmartContract) HarvestCrop(ctx contractapi.TransactionContextInterface, id string) (*CropRecord, error) {
+    crop, err := f.readCrop(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if crop.Deleted != nil {
+        return nil, fmt.Errorf("the crop record %s has been deleted", id)
+    }
+
+    return crop, nil
+}
+
+func (f *SmartContract) readCrop(ctx contractapi.TransactionContextInterface, id string) (*CropRecord, error) {
     cropJSON, err := ctx.GetStub().GetState(id)
     if err != nil {
         return nil, fmt.Errorf("failed to read crop record from world state: %v", err)
@@ -121,6 +216,14 @@ func (f *SmartContract) HarvestCrop(ctx contractapi.TransactionContextInterface,
 }
 
 func (f *SmartContract) GetAllCrops(ctx contractapi.TransactionContextInterface) ([]*CropRecord, error) {
+    return f.getAllCrops(ctx, false)
+}
+
+func (f *SmartContract) GetAllCropsIncludingDeleted(ctx contractapi.TransactionContextInterface) ([]*CropRecord, error) {
+    return f.getAllCrops(ctx, true)
+}
+
+func (f *SmartContract) getAllCrops(ctx contractapi.TransactionContextInterface, includeDeleted bool) ([]*CropRecord, error) {
     resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
     if err != nil {
         return nil, err
@@ -139,6 +242,9 @@ func (f *SmartContract) GetAllCrops(ctx contractapi.TransactionContextInterface)
         if err != nil {
             return nil, err
         }
+        if crop.Deleted != nil && !includeDeleted {
+            continue
+        }
         crops = append(crops, &crop)
     }
 
@@ -199,14 +305,24 @@ func (f *SmartContract) CropExists(ctx contractapi.TransactionContextInterface,
     return crop != nil, nil
 }
 
-func (f *SmartContract) RemoveCrop(ctx contractapi.TransactionContextInterface, id string) error {
-    exists, err := f.CropExists(ctx, id)
+func (f *SmartContract) RemoveCrop(ctx contractapi.TransactionContextInterface, id string, reason string) error {
+    crop, err := f.HarvestCrop(ctx, id)
     if err != nil {
         return err
     }
-    if !exists {
-        return fmt.Errorf("the crop record %s does not exist", id)
+
+    tombstone, err := newTombstone(ctx, reason)
+    if err != nil {
+        return err
     }
+    crop.Deleted = tombstone
+    crop.Timestamp = tombstone.DeletedAt.String()
+    crop.Version++
 
-    return ctx.GetStub().DelState(id)
+    cropJSON, err := json.Marshal(crop)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(id, cropJSON)
 }
@@ -0,0 +1,125 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "mime"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type DocumentRef struct {
+    CID           string    `json:"cid"`
+    CIDVersion    int       `json:"cidVersion"`
+    Codec         string    `json:"codec"`
+    HashAlgorithm string    `json:"hashAlgorithm"`
+    MimeType      string    `json:"mimeType"`
+    Size          int64     `json:"size"`
+    AddedBy       string    `json:"addedBy"`
+    AddedAt       time.Time `json:"addedAt"`
+}
+
+// AttachDocument references an off-chain document by content identifier. The CID is
+// validated and stored in canonical form, so the same document cannot be attached twice
+// under different encodings.
+func (f *SmartContract) AttachDocument(ctx contractapi.TransactionContextInterface, id string, cid string, mimeType string, size int64) error {
+    crop, err := f.HarvestCrop(ctx, id)
+    if err != nil {
+        return err
+    }
+
+    parsed, err := ParseCID(cid)
+    if err != nil {
+        return err
+    }
+    mediaType, _, err := mime.ParseMediaType(mimeType)
+    if err != nil {
+        return fmt.Errorf("invalid MIME type %q: %v", mimeType, err)
+    }
+    if size <= 0 {
+        return fmt.Errorf("document size must be positive")
+    }
+
+    canonical := parsed.String()
+    for _, document := range crop.Documents {
+        if document.CID == canonical {
+            return fmt.Errorf("document %s is already attached to crop record %s", canonical, id)
+        }
+    }
+
+    _, addedBy, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    addedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    crop.Documents = append(crop.Documents, DocumentRef{
+        CID:           canonical,
+        CIDVersion:    parsed.Version,
+        Codec:         parsed.CodecName(),
+        HashAlgorithm: parsed.HashAlgorithm,
+        MimeType:      mediaType,
+        Size:          size,
+        AddedBy:       addedBy,
+        AddedAt:       addedAt,
+    })
+    crop.Timestamp = addedAt.String()
+    crop.Version++
+
+    cropJSON, err := json.Marshal(crop)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(id, cropJSON)
+}
+
+func (f *SmartContract) DetachDocument(ctx contractapi.TransactionContextInterface, id string, cid string) error {
+    crop, err := f.HarvestCrop(ctx, id)
+    if err != nil {
+        return err
+    }
+
+    parsed, err := ParseCID(cid)
+    if err != nil {
+        return err
+    }
+    canonical := parsed.String()
+
+    remaining := crop.Documents[:0]
+    for _, document := range crop.Documents {
+        if document.CID != canonical {
+            remaining = append(remaining, document)
+        }
+    }
+    if len(remaining) == len(crop.Documents) {
+        return fmt.Errorf("document %s is not attached to crop record %s", canonical, id)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    crop.Documents = remaining
+    crop.Timestamp = now.String()
+    crop.Version++
+
+    cropJSON, err := json.Marshal(crop)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(id, cropJSON)
+}
+
+func (f *SmartContract) GetDocuments(ctx contractapi.TransactionContextInterface, id string) ([]DocumentRef, error) {
+    crop, err := f.HarvestCrop(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    return crop.Documents, nil
+}
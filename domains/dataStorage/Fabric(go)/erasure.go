@@ -0,0 +1,359 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    personalDataCollection = "farmerPersonalDataCollection"
+
+    transientPersonalKey = "personal"
+
+    erasureRequestObjectType     = "ErasureRequest"
+    erasureCertificateObjectType = "ErasureCertificate"
+
+    erasureStatusPending  = "pending"
+    erasureStatusExecuted = "executed"
+)
+
+type PersonalData struct {
+    ID       string `json:"id"`
+    Personal string `json:"personal"`
+    Salt     string `json:"salt"`
+}
+
+type ErasureRequest struct {
+    ID             string    `json:"id"`
+    RecordID       string    `json:"recordId"`
+    Reason         string    `json:"reason"`
+    Status         string    `json:"status"`
+    RequestedBy    string    `json:"requestedBy"`
+    RequestedByMSP string    `json:"requestedByMsp"`
+    RequestedAt    time.Time `json:"requestedAt"`
+    ExecutedAt     time.Time `json:"executedAt"`
+}
+
+// ErasureCertificate is the public proof that personal data was purged. It keeps the
+// salted hash that was on the record, so a holder of the original data can still show
+// what was erased, but nothing from which the data itself can be recovered.
+type ErasureCertificate struct {
+    RequestID        string    `json:"requestId"`
+    RecordID         string    `json:"recordId"`
+    Collection       string    `json:"collection"`
+    PersonalDataHash string    `json:"personalDataHash"`
+    Reason           string    `json:"reason"`
+    RequestedBy      string    `json:"requestedBy"`
+    RequestedByMSP   string    `json:"requestedByMsp"`
+    ErasedBy         string    `json:"erasedBy"`
+    ErasedByMSP      string    `json:"erasedByMsp"`
+    ErasedAt         time.Time `json:"erasedAt"`
+    TxId             string    `json:"txId"`
+}
+
+// SetPersonalData stores farmer-supplied personal fields, passed in the transient map under
+// "personal" with a "salt", in the personal data collection. Only the salted hash goes on
+// the public record, so the record and its non-personal metadata survive an erasure. Once
+// erasure has been requested or carried out, a record takes no personal data again.
+func (f *SmartContract) SetPersonalData(ctx contractapi.TransactionContextInterface, recordID string, expectedVersion uint64) error {
+    crop, err := f.HarvestCrop(ctx, recordID)
+    if err != nil {
+        return err
+    }
+    if crop.Version != expectedVersion {
+        return &VersionConflictError{ID: recordID, Expected: expectedVersion, Actual: crop.Version}
+    }
+    if crop.ErasureID != "" {
+        return fmt.Errorf("the personal data of crop record %s was erased by request %s", recordID, crop.ErasureID)
+    }
+    pending, err := f.erasureRequests(ctx, recordID, true)
+    if err != nil {
+        return err
+    }
+    if len(pending) > 0 {
+        return fmt.Errorf("erasure request %s for crop record %s is pending", pending[0].ID, recordID)
+    }
+
+    transient, err := ctx.GetStub().GetTransient()
+    if err != nil {
+        return fmt.Errorf("failed to read transient map: %v", err)
+    }
+    personal, ok := transient[transientPersonalKey]
+    if !ok || len(personal) == 0 {
+        return fmt.Errorf("transient %q is required", transientPersonalKey)
+    }
+    salt := transient[transientSaltKey]
+    if len(salt) < minSaltLength {
+        return fmt.Errorf("transient %q must be at least %d bytes", transientSaltKey, minSaltLength)
+    }
+
+    personalJSON, err := json.Marshal(PersonalData{
+        ID:       recordID,
+        Personal: string(personal),
+        Salt:     string(salt),
+    })
+    if err != nil {
+        return err
+    }
+    err = ctx.GetStub().PutPrivateData(personalDataCollection, recordID, personalJSON)
+    if err != nil {
+        return fmt.Errorf("failed to put personal data for crop record %s: %v", recordID, err)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    crop.PersonalHash = saltedPayloadHash(personal, salt)
+    crop.Timestamp = now.String()
+    crop.Version++
+
+    cropJSON, err := json.Marshal(crop)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(recordID, cropJSON)
+}
+
+func (f *SmartContract) GetPersonalData(ctx contractapi.TransactionContextInterface, recordID string) (*PersonalData, error) {
+    personalJSON, err := ctx.GetStub().GetPrivateData(personalDataCollection, recordID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read personal data: %v", err)
+    }
+    if personalJSON == nil {
+        return nil, fmt.Errorf("no personal data is stored for crop record %s", recordID)
+    }
+
+    var personal PersonalData
+    err = json.Unmarshal(personalJSON, &personal)
+    if err != nil {
+        return nil, err
+    }
+
+    return &personal, nil
+}
+
+// RequestErasure files a right-to-erasure request for the personal data attached to a
+// crop record. Deleted records can be erased too, since a tombstone keeps the private data.
+func (f *SmartContract) RequestErasure(ctx contractapi.TransactionContextInterface, recordID string, reason string) (string, error) {
+    crop, err := f.readCrop(ctx, recordID)
+    if err != nil {
+        return "", err
+    }
+    if crop.PersonalHash == "" || crop.ErasureID != "" {
+        return "", fmt.Errorf("the crop record %s holds no personal data to erase", recordID)
+    }
+
+    pending, err := f.erasureRequests(ctx, recordID, true)
+    if err != nil {
+        return "", err
+    }
+    if len(pending) > 0 {
+        return "", fmt.Errorf("erasure request %s for crop record %s is already pending", pending[0].ID, recordID)
+    }
+
+    requestedByMSP, requestedBy, err := callerIdentity(ctx)
+    if err != nil {
+        return "", err
+    }
+    requestedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    request := &ErasureRequest{
+        ID:             ctx.GetStub().GetTxID(),
+        RecordID:       recordID,
+        Reason:         reason,
+        Status:         erasureStatusPending,
+        RequestedBy:    requestedBy,
+        RequestedByMSP: requestedByMSP,
+        RequestedAt:    requestedAt,
+    }
+    if err := putErasureRequest(ctx, request); err != nil {
+        return "", err
+    }
+
+    return request.ID, nil
+}
+
+// ExecuteErasure purges the record's personal data from the collection, including the
+// peers' private data history, and writes an erasure certificate. Admin only.
+func (f *SmartContract) ExecuteErasure(ctx contractapi.TransactionContextInterface, recordID string, requestID string) (*ErasureCertificate, error) {
+    if err := requireAdmin(ctx); err != nil {
+        return nil, err
+    }
+
+    request, err := readErasureRequest(ctx, recordID, requestID)
+    if err != nil {
+        return nil, err
+    }
+    if request.Status != erasureStatusPending {
+        return nil, fmt.Errorf("erasure request %s is already %s", requestID, request.Status)
+    }
+    crop, err := f.readCrop(ctx, recordID)
+    if err != nil {
+        return nil, err
+    }
+
+    err = ctx.GetStub().PurgePrivateData(personalDataCollection, recordID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to purge personal data for crop record %s: %v", recordID, err)
+    }
+
+    erasedByMSP, erasedBy, err := callerIdentity(ctx)
+    if err != nil {
+        return nil, err
+    }
+    erasedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    certificate := &ErasureCertificate{
+        RequestID:        requestID,
+        RecordID:         recordID,
+        Collection:       personalDataCollection,
+        PersonalDataHash: crop.PersonalHash,
+        Reason:           request.Reason,
+        RequestedBy:      request.RequestedBy,
+        RequestedByMSP:   request.RequestedByMSP,
+        ErasedBy:         erasedBy,
+        ErasedByMSP:      erasedByMSP,
+        ErasedAt:         erasedAt,
+        TxId:             ctx.GetStub().GetTxID(),
+    }
+    certificateJSON, err := json.Marshal(certificate)
+    if err != nil {
+        return nil, err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(erasureCertificateObjectType, []string{recordID, requestID})
+    if err != nil {
+        return nil, err
+    }
+    err = ctx.GetStub().PutState(key, certificateJSON)
+    if err != nil {
+        return nil, err
+    }
+
+    request.Status = erasureStatusExecuted
+    request.ExecutedAt = erasedAt
+    if err := putErasureRequest(ctx, request); err != nil {
+        return nil, err
+    }
+
+    crop.ErasureID = requestID
+    crop.Timestamp = erasedAt.String()
+    crop.Version++
+    cropJSON, err := json.Marshal(crop)
+    if err != nil {
+        return nil, err
+    }
+    err = ctx.GetStub().PutState(recordID, cropJSON)
+    if err != nil {
+        return nil, err
+    }
+
+    return certificate, nil
+}
+
+func (f *SmartContract) GetPendingErasureRequests(ctx contractapi.TransactionContextInterface) ([]*ErasureRequest, error) {
+    return f.erasureRequests(ctx, "", true)
+}
+
+func (f *SmartContract) GetErasureCertificates(ctx contractapi.TransactionContextInterface, recordID string) ([]*ErasureCertificate, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureCertificateObjectType, []string{recordID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var certificates []*ErasureCertificate
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var certificate ErasureCertificate
+        err = json.Unmarshal(queryResponse.Value, &certificate)
+        if err != nil {
+            return nil, err
+        }
+        certificates = append(certificates, &certificate)
+    }
+
+    return certificates, nil
+}
+
+// erasureRequests lists the requests for one record, or for all records when recordID
+// is empty.
+func (f *SmartContract) erasureRequests(ctx contractapi.TransactionContextInterface, recordID string, pendingOnly bool) ([]*ErasureRequest, error) {
+    var attributes []string
+    if recordID != "" {
+        attributes = []string{recordID}
+    }
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(erasureRequestObjectType, attributes)
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var requests []*ErasureRequest
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var request ErasureRequest
+        err = json.Unmarshal(queryResponse.Value, &request)
+        if err != nil {
+            return nil, err
+        }
+        if pendingOnly && request.Status != erasureStatusPending {
+            continue
+        }
+        requests = append(requests, &request)
+    }
+
+    return requests, nil
+}
+
+func readErasureRequest(ctx contractapi.TransactionContextInterface, recordID string, requestID string) (*ErasureRequest, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(erasureRequestObjectType, []string{recordID, requestID})
+    if err != nil {
+        return nil, err
+    }
+    requestJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read erasure request from world state: %v", err)
+    }
+    if requestJSON == nil {
+        return nil, fmt.Errorf("erasure request %s for crop record %s does not exist", requestID, recordID)
+    }
+
+    var request ErasureRequest
+    err = json.Unmarshal(requestJSON, &request)
+    if err != nil {
+        return nil, err
+    }
+
+    return &request, nil
+}
+
+func putErasureRequest(ctx contractapi.TransactionContextInterface, request *ErasureRequest) error {
+    key, err := ctx.GetStub().CreateCompositeKey(erasureRequestObjectType, []string{request.RecordID, request.ID})
+    if err != nil {
+        return err
+    }
+    requestJSON, err := json.Marshal(request)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, requestJSON)
+}
@@ -0,0 +1,428 @@
+package chaincode
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "regexp"
+    "sort"
+    "unicode/utf8"
+)
+
+// jsonSchema is the compiled form of the JSON Schema subset the registry accepts:
+// type, properties, required, additionalProperties, items, enum, const, minimum,
+// maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength, pattern,
+// minItems and maxItems. Keywords that would need remote resolution or that change
+// validation in ways this subset cannot honour are rejected when the schema is
+// registered, so a schema never silently accepts more than its author intended.
+type jsonSchema struct {
+    types                []string
+    properties           map[string]*jsonSchema
+    required             []string
+    additionalProperties *jsonSchema
+    noAdditional         bool
+    items                *jsonSchema
+    enum                 []interface{}
+    constValue           interface{}
+    hasConst             bool
+    minimum              *big.Float
+    maximum              *big.Float
+    exclusiveMinimum     *big.Float
+    exclusiveMaximum     *big.Float
+    minLength            *int
+    maxLength            *int
+    pattern              *regexp.Regexp
+    minItems             *int
+    maxItems             *int
+}
+
+var annotationKeywords = map[string]bool{
+    "$schema":     true,
+    "$id":         true,
+    "$comment":    true,
+    "title":       true,
+    "description": true,
+    "default":     true,
+    "examples":    true,
+    "format":      true,
+}
+
+var schemaTypes = map[string]bool{
+    "object":  true,
+    "array":   true,
+    "string":  true,
+    "number":  true,
+    "integer": true,
+    "boolean": true,
+    "null":    true,
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        return nil, err
+    }
+    if decoder.More() {
+        return nil, fmt.Errorf("unexpected data after top-level value")
+    }
+    return value, nil
+}
+
+func compileSchema(data []byte) (*jsonSchema, error) {
+    raw, err := decodeJSON(data)
+    if err != nil {
+        return nil, fmt.Errorf("schema is not valid JSON: %v", err)
+    }
+    return compileSchemaNode(raw, "#")
+}
+
+func compileSchemaNode(raw interface{}, path string) (*jsonSchema, error) {
+    node, ok := raw.(map[string]interface{})
+    if !ok {
+        return nil, fmt.Errorf("%s: schema must be an object", path)
+    }
+
+    schema := &jsonSchema{}
+    for _, keyword := range sortedKeys(node) {
+        value := node[keyword]
+        at := path + "/" + keyword
+        var err error
+
+        switch keyword {
+        case "type":
+            schema.types, err = compileTypes(value, at)
+        case "properties":
+            props, ok := value.(map[string]interface{})
+            if !ok {
+                return nil, fmt.Errorf("%s: must be an object", at)
+            }
+            schema.properties = make(map[string]*jsonSchema, len(props))
+            for _, name := range sortedKeys(props) {
+                schema.properties[name], err = compileSchemaNode(props[name], at+"/"+name)
+                if err != nil {
+                    return nil, err
+                }
+            }
+        case "required":
+            schema.required, err = compileStringList(value, at)
+        case "additionalProperties":
+            if allowed, ok := value.(bool); ok {
+                schema.noAdditional = !allowed
+            } else {
+                schema.additionalProperties, err = compileSchemaNode(value, at)
+            }
+        case "items":
+            schema.items, err = compileSchemaNode(value, at)
+        case "enum":
+            list, ok := value.([]interface{})
+            if !ok || len(list) == 0 {
+                return nil, fmt.Errorf("%s: must be a non-empty array", at)
+            }
+            schema.enum = list
+        case "const":
+            schema.constValue = value
+            schema.hasConst = true
+        case "minimum":
+            schema.minimum, err = compileNumber(value, at)
+        case "maximum":
+            schema.maximum, err = compileNumber(value, at)
+        case "exclusiveMinimum":
+            schema.exclusiveMinimum, err = compileNumber(value, at)
+        case "exclusiveMaximum":
+            schema.exclusiveMaximum, err = compileNumber(value, at)
+        case "minLength":
+            schema.minLength, err = compileCount(value, at)
+        case "maxLength":
+            schema.maxLength, err = compileCount(value, at)
+        case "minItems":
+            schema.minItems, err = compileCount(value, at)
+        case "maxItems":
+            schema.maxItems, err = compileCount(value, at)
+        case "pattern":
+            expr, ok := value.(string)
+            if !ok {
+                return nil, fmt.Errorf("%s: must be a string", at)
+            }
+            schema.pattern, err = regexp.Compile(expr)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %v", at, err)
+            }
+        default:
+            if !annotationKeywords[keyword] {
+                return nil, fmt.Errorf("%s: keyword is not supported", at)
+            }
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    return schema, nil
+}
+
+func compileTypes(value interface{}, path string) ([]string, error) {
+    var types []string
+    switch v := value.(type) {
+    case string:
+        types = []string{v}
+    case []interface{}:
+        list, err := compileStringList(v, path)
+        if err != nil {
+            return nil, err
+        }
+        types = list
+    default:
+        return nil, fmt.Errorf("%s: must be a string or an array of strings", path)
+    }
+
+    for _, t := range types {
+        if !schemaTypes[t] {
+            return nil, fmt.Errorf("%s: unknown type %q", path, t)
+        }
+    }
+    return types, nil
+}
+
+func compileStringList(value interface{}, path string) ([]string, error) {
+    list, ok := value.([]interface{})
+    if !ok {
+        return nil, fmt.Errorf("%s: must be an array of strings", path)
+    }
+    strs := make([]string, 0, len(list))
+    for _, item := range list {
+        s, ok := item.(string)
+        if !ok {
+            return nil, fmt.Errorf("%s: must be an array of strings", path)
+        }
+        strs = append(strs, s)
+    }
+    return strs, nil
+}
+
+func compileNumber(value interface{}, path string) (*big.Float, error) {
+    n, ok := value.(json.Number)
+    if !ok {
+        return nil, fmt.Errorf("%s: must be a number", path)
+    }
+    f, _, err := big.ParseFloat(n.String(), 10, 256, big.ToNearestEven)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %v", path, err)
+    }
+    return f, nil
+}
+
+func compileCount(value interface{}, path string) (*int, error) {
+    n, ok := value.(json.Number)
+    if !ok {
+        return nil, fmt.Errorf("%s: must be a non-negative integer", path)
+    }
+    i, err := n.Int64()
+    if err != nil || i < 0 {
+        return nil, fmt.Errorf("%s: must be a non-negative integer", path)
+    }
+    count := int(i)
+    return &count, nil
+}
+
+// validate reports the first violation found, walking object properties in sorted order
+// so every endorser returns the same error for the same document.
+func (s *jsonSchema) validate(value interface{}, path string) error {
+    if len(s.types) > 0 && !matchesAnyType(value, s.types) {
+        return fmt.Errorf("%s: expected %s, got %s", path, joinTypes(s.types), jsonTypeOf(value))
+    }
+    if s.hasConst && !jsonEqual(value, s.constValue) {
+        return fmt.Errorf("%s: value does not match the required constant", path)
+    }
+    if s.enum != nil {
+        found := false
+        for _, candidate := range s.enum {
+            if jsonEqual(value, candidate) {
+                found = true
+                break
+            }
+        }
+        if !found {
+            return fmt.Errorf("%s: value is not one of the allowed values", path)
+        }
+    }
+
+    switch v := value.(type) {
+    case map[string]interface{}:
+        return s.validateObject(v, path)
+    case []interface{}:
+        return s.validateArray(v, path)
+    case string:
+        return s.validateString(v, path)
+    case json.Number:
+        return s.validateNumber(v, path)
+    }
+    return nil
+}
+
+func (s *jsonSchema) validateObject(object map[string]interface{}, path string) error {
+    for _, name := range s.required {
+        if _, ok := object[name]; !ok {
+            return fmt.Errorf("%s: missing required property %q", path, name)
+        }
+    }
+
+    for _, name := range sortedKeys(object) {
+        at := path + "." + name
+        if property, ok := s.properties[name]; ok {
+            if err := property.validate(object[name], at); err != nil {
+                return err
+            }
+            continue
+        }
+        if s.noAdditional {
+            return fmt.Errorf("%s: property is not allowed", at)
+        }
+        if s.additionalProperties != nil {
+            if err := s.additionalProperties.validate(object[name], at); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (s *jsonSchema) validateArray(array []interface{}, path string) error {
+    if s.minItems != nil && len(array) < *s.minItems {
+        return fmt.Errorf("%s: expected at least %d items, got %d", path, *s.minItems, len(array))
+    }
+    if s.maxItems != nil && len(array) > *s.maxItems {
+        return fmt.Errorf("%s: expected at most %d items, got %d", path, *s.maxItems, len(array))
+    }
+    if s.items != nil {
+        for i, item := range array {
+            if err := s.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (s *jsonSchema) validateString(str string, path string) error {
+    length := utf8.RuneCountInString(str)
+    if s.minLength != nil && length < *s.minLength {
+        return fmt.Errorf("%s: expected at least %d characters, got %d", path, *s.minLength, length)
+    }
+    if s.maxLength != nil && length > *s.maxLength {
+        return fmt.Errorf("%s: expected at most %d characters, got %d", path, *s.maxLength, length)
+    }
+    if s.pattern != nil && !s.pattern.MatchString(str) {
+        return fmt.Errorf("%s: %q does not match pattern %s", path, str, s.pattern)
+    }
+    return nil
+}
+
+func (s *jsonSchema) validateNumber(n json.Number, path string) error {
+    value, _, err := big.ParseFloat(n.String(), 10, 256, big.ToNearestEven)
+    if err != nil {
+        return fmt.Errorf("%s: %v", path, err)
+    }
+    if s.minimum != nil && value.Cmp(s.minimum) < 0 {
+        return fmt.Errorf("%s: %s is less than minimum %s", path, n, s.minimum.Text('g', -1))
+    }
+    if s.maximum != nil && value.Cmp(s.maximum) > 0 {
+        return fmt.Errorf("%s: %s is greater than maximum %s", path, n, s.maximum.Text('g', -1))
+    }
+    if s.exclusiveMinimum != nil && value.Cmp(s.exclusiveMinimum) <= 0 {
+        return fmt.Errorf("%s: %s must be greater than %s", path, n, s.exclusiveMinimum.Text('g', -1))
+    }
+    if s.exclusiveMaximum != nil && value.Cmp(s.exclusiveMaximum) >= 0 {
+        return fmt.Errorf("%s: %s must be less than %s", path, n, s.exclusiveMaximum.Text('g', -1))
+    }
+    return nil
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+    actual := jsonTypeOf(value)
+    for _, t := range types {
+        if t == actual || (t == "number" && actual == "integer") {
+            return true
+        }
+    }
+    return false
+}
+
+func jsonTypeOf(value interface{}) string {
+    switch v := value.(type) {
+    case nil:
+        return "null"
+    case bool:
+        return "boolean"
+    case string:
+        return "string"
+    case []interface{}:
+        return "array"
+    case map[string]interface{}:
+        return "object"
+    case json.Number:
+        f, _, err := big.ParseFloat(v.String(), 10, 256, big.ToNearestEven)
+        if err == nil && f.IsInt() {
+            return "integer"
+        }
+        return "number"
+    }
+    return fmt.Sprintf("%T", value)
+}
+
+func jsonEqual(a, b interface{}) bool {
+    switch av := a.(type) {
+    case json.Number:
+        bv, ok := b.(json.Number)
+        if !ok {
+            return false
+        }
+        af, _, errA := big.ParseFloat(av.String(), 10, 256, big.ToNearestEven)
+        bf, _, errB := big.ParseFloat(bv.String(), 10, 256, big.ToNearestEven)
+        return errA == nil && errB == nil && af.Cmp(bf) == 0
+    case []interface{}:
+        bv, ok := b.([]interface{})
+        if !ok || len(av) != len(bv) {
+            return false
+        }
+        for i := range av {
+            if !jsonEqual(av[i], bv[i]) {
+                return false
+            }
+        }
+        return true
+    case map[string]interface{}:
+        bv, ok := b.(map[string]interface{})
+        if !ok || len(av) != len(bv) {
+            return false
+        }
+        for k, v := range av {
+            other, ok := bv[k]
+            if !ok || !jsonEqual(v, other) {
+                return false
+            }
+        }
+        return true
+    default:
+        return a == b
+    }
+}
+
+func joinTypes(types []string) string {
+    if len(types) == 1 {
+        return types[0]
+    }
+    return fmt.Sprintf("one of %v", types)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
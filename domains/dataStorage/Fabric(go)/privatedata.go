@@ -0,0 +1,113 @@
+package chaincode
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    cropPrivateCollection = "cropPrivateDataCollection"
+
+    transientPayloadKey = "payload"
+    transientSaltKey    = "salt"
+
+    // minSaltLength keeps the public hash from being brute-forced for short, guessable payloads.
+    minSaltLength = 16
+)
+
+type CropPrivateData struct {
+    ID      string `json:"id"`
+    Payload string `json:"payload"`
+    Salt    string `json:"salt"`
+}
+
+// readTransientPayload returns the payload and salt passed in the transient map, if any.
+// The salt has to come from the client because every endorser must compute the same hash.
+func readTransientPayload(ctx contractapi.TransactionContextInterface) ([]byte, []byte, bool, error) {
+    transient, err := ctx.GetStub().GetTransient()
+    if err != nil {
+        return nil, nil, false, fmt.Errorf("failed to read transient map: %v", err)
+    }
+
+    payload, ok := transient[transientPayloadKey]
+    if !ok {
+        return nil, nil, false, nil
+    }
+    salt := transient[transientSaltKey]
+    if len(salt) < minSaltLength {
+        return nil, nil, false, fmt.Errorf("transient %q must be at least %d bytes", transientSaltKey, minSaltLength)
+    }
+
+    return payload, salt, true, nil
+}
+
+func saltedPayloadHash(payload []byte, salt []byte) string {
+    hash := sha256.New()
+    hash.Write(salt)
+    hash.Write(payload)
+    return hex.EncodeToString(hash.Sum(nil))
+}
+
+func putPrivatePayload(ctx contractapi.TransactionContextInterface, id string, payload []byte, salt []byte) (string, error) {
+    private := CropPrivateData{
+        ID:      id,
+        Payload: string(payload),
+        Salt:    string(salt),
+    }
+
+    privateJSON, err := json.Marshal(private)
+    if err != nil {
+        return "", err
+    }
+
+    err = ctx.GetStub().PutPrivateData(cropPrivateCollection, id, privateJSON)
+    if err != nil {
+        return "", fmt.Errorf("failed to put private payload for crop record %s: %v", id, err)
+    }
+
+    return saltedPayloadHash(payload, salt), nil
+}
+
+func (f *SmartContract) GetCropPayload(ctx contractapi.TransactionContextInterface, id string) (*CropPrivateData, error) {
+    privateJSON, err := ctx.GetStub().GetPrivateData(cropPrivateCollection, id)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read private payload: %v", err)
+    }
+    if privateJSON == nil {
+        return nil, fmt.Errorf("no private payload is stored for crop record %s", id)
+    }
+
+    var private CropPrivateData
+    err = json.Unmarshal(privateJSON, &private)
+    if err != nil {
+        return nil, err
+    }
+
+    return &private, nil
+}
+
+// VerifyCropPayload checks a disclosed payload and salt, passed in the transient map,
+// against the hash on the public ledger. It does not need access to the collection.
+func (f *SmartContract) VerifyCropPayload(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+    crop, err := f.HarvestCrop(ctx, id)
+    if err != nil {
+        return false, err
+    }
+    if crop.DataHash == "" {
+        return false, fmt.Errorf("the crop record %s has no private payload", id)
+    }
+
+    payload, salt, ok, err := readTransientPayload(ctx)
+    if err != nil {
+        return false, err
+    }
+    if !ok {
+        return false, fmt.Errorf("transient %q is required", transientPayloadKey)
+    }
+
+    return saltedPayloadHash(payload, salt) == crop.DataHash, nil
+}
@@ -0,0 +1,448 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    procedureObjectType        = "Procedure"
+    procedureAccessObjectType  = "ProcedureAccess"
+    procedureGranteeObjectType = "ProcedureGrantee"
+)
+
+type ProcedureRecord struct {
+    ID            string    `json:"id"`
+    FarmID        string    `json:"farmId"`
+    ProcedureHash string    `json:"procedureHash"`
+    Category      string    `json:"category"`
+    Timestamp     time.Time `json:"timestamp"`
+    Recorder      string    `json:"recorder"`
+    RecorderMSP   string    `json:"recorderMsp"`
+}
+
+type ProcedureGrant struct {
+    ProcedureID string    `json:"procedureId"`
+    GranteeMSP  string    `json:"granteeMsp"`
+    GranteeID   string    `json:"granteeId"`
+    Purpose     string    `json:"purpose"`
+    ExpiresAt   time.Time `json:"expiresAt"`
+    GrantedBy   string    `json:"grantedBy"`
+    GrantedAt   time.Time `json:"grantedAt"`
+}
+
+func (f *SmartContract) RecordProcedure(ctx contractapi.TransactionContextInterface, id string, farmID string, procedureHash string, category string) error {
+    key, err := ctx.GetStub().CreateCompositeKey(procedureObjectType, []string{id})
+    if err != nil {
+        return err
+    }
+    existing, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return fmt.Errorf("failed to read procedure record from world state: %v", err)
+    }
+    if existing != nil {
+        return fmt.Errorf("the procedure record %s already exists", id)
+    }
+
+    recorderMSP, recorder, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    timestamp, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    procedure := ProcedureRecord{
+        ID:            id,
+        FarmID:        farmID,
+        ProcedureHash: procedureHash,
+        Category:      category,
+        Timestamp:     timestamp,
+        Recorder:      recorder,
+        RecorderMSP:   recorderMSP,
+    }
+
+    procedureJSON, err := json.Marshal(procedure)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, procedureJSON)
+}
+
+// GrantAccess lets a grantee read the procedure for one purpose until expiresAt, given
+// as an RFC 3339 timestamp. Granting again replaces any earlier grant to the same identity.
+// Expired grants on the record are cleaned up along the way.
+func (f *SmartContract) GrantAccess(ctx contractapi.TransactionContextInterface, id string, granteeMSP string, granteeID string, purpose string, expiresAt string) error {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return err
+    }
+    if err := requireRecorder(ctx, procedure); err != nil {
+        return fmt.Errorf("only the recorder can grant access: %v", err)
+    }
+    if granteeMSP == "" || granteeID == "" {
+        return fmt.Errorf("grantee MSP ID and identity must not be empty")
+    }
+    if purpose == "" {
+        return fmt.Errorf("access grants must state a purpose")
+    }
+
+    expiry, err := time.Parse(time.RFC3339, expiresAt)
+    if err != nil {
+        return fmt.Errorf("invalid expiry %q: %v", expiresAt, err)
+    }
+    _, grantedBy, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    grantedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if !expiry.After(grantedAt) {
+        return fmt.Errorf("expiry %s is not in the future", expiresAt)
+    }
+    if err := purgeExpiredGrants(ctx, id, grantedAt); err != nil {
+        return err
+    }
+
+    grant := ProcedureGrant{
+        ProcedureID: id,
+        GranteeMSP:  granteeMSP,
+        GranteeID:   granteeID,
+        Purpose:     purpose,
+        ExpiresAt:   expiry,
+        GrantedBy:   grantedBy,
+        GrantedAt:   grantedAt,
+    }
+
+    grantJSON, err := json.Marshal(grant)
+    if err != nil {
+        return err
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(procedureAccessObjectType, []string{id, granteeMSP, granteeID})
+    if err != nil {
+        return err
+    }
+    err = ctx.GetStub().PutState(key, grantJSON)
+    if err != nil {
+        return err
+    }
+
+    granteeKey, err := ctx.GetStub().CreateCompositeKey(procedureGranteeObjectType, []string{granteeMSP, granteeID, id})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(granteeKey, []byte{0x00})
+}
+
+// RevokeAccess removes a grant before it expires, and cleans up expired grants on the
+// record along the way.
+func (f *SmartContract) RevokeAccess(ctx contractapi.TransactionContextInterface, id string, granteeMSP string, granteeID string) error {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return err
+    }
+    if err := requireRecorder(ctx, procedure); err != nil {
+        return fmt.Errorf("only the recorder can revoke access: %v", err)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if err := purgeExpiredGrants(ctx, id, now); err != nil {
+        return err
+    }
+
+    grant, err := readGrant(ctx, id, granteeMSP, granteeID)
+    if err != nil {
+        return err
+    }
+    if grant == nil {
+        return fmt.Errorf("%s of %s has no access to procedure record %s", granteeID, granteeMSP, id)
+    }
+
+    return deleteGrant(ctx, grant)
+}
+
+func (f *SmartContract) HasAccess(ctx contractapi.TransactionContextInterface, id string, mspID string, clientID string, purpose string) (bool, error) {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return false, err
+    }
+
+    return hasProcedureAccess(ctx, procedure, mspID, clientID, purpose)
+}
+
+// GetProcedure returns a procedure record to its recorder. Grantees read through
+// RequestProcedureRead and AuditedReadProcedure, which leave an access receipt.
+func (f *SmartContract) GetProcedure(ctx contractapi.TransactionContextInterface, id string) (*ProcedureRecord, error) {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if err := requireRecorder(ctx, procedure); err != nil {
+        return nil, fmt.Errorf("grantees must read procedure record %s through an access receipt: %v", id, err)
+    }
+
+    return procedure, nil
+}
+
+// ListActiveGrants returns the unexpired grants on a procedure record. Like the public
+// access list in the Solidity contract, grant metadata is readable by any member.
+func (f *SmartContract) ListActiveGrants(ctx contractapi.TransactionContextInterface, id string) ([]*ProcedureGrant, error) {
+    if _, err := f.readProcedure(ctx, id); err != nil {
+        return nil, err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(procedureAccessObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var grants []*ProcedureGrant
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var grant ProcedureGrant
+        err = json.Unmarshal(queryResponse.Value, &grant)
+        if err != nil {
+            return nil, err
+        }
+        if now.Before(grant.ExpiresAt) {
+            grants = append(grants, &grant)
+        }
+    }
+
+    return grants, nil
+}
+
+func (f *SmartContract) ListActiveGrantsForGrantee(ctx contractapi.TransactionContextInterface, granteeMSP string, granteeID string) ([]*ProcedureGrant, error) {
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(procedureGranteeObjectType, []string{granteeMSP, granteeID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var grants []*ProcedureGrant
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        _, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+        grant, err := readGrant(ctx, attributes[2], granteeMSP, granteeID)
+        if err != nil {
+            return nil, err
+        }
+        if grant != nil && now.Before(grant.ExpiresAt) {
+            grants = append(grants, grant)
+        }
+    }
+
+    return grants, nil
+}
+
+// GetFarmProcedures returns the caller's own procedure records for a farm.
+func (f *SmartContract) GetFarmProcedures(ctx contractapi.TransactionContextInterface, farmID string) ([]*ProcedureRecord, error) {
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(procedureObjectType, []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var procedures []*ProcedureRecord
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var procedure ProcedureRecord
+        err = json.Unmarshal(queryResponse.Value, &procedure)
+        if err != nil {
+            return nil, err
+        }
+        if procedure.FarmID == farmID && procedure.RecorderMSP == mspID && procedure.Recorder == clientID {
+            procedures = append(procedures, &procedure)
+        }
+    }
+
+    return procedures, nil
+}
+
+func (f *SmartContract) readProcedure(ctx contractapi.TransactionContextInterface, id string) (*ProcedureRecord, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(procedureObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    procedureJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read procedure record from world state: %v", err)
+    }
+    if procedureJSON == nil {
+        return nil, fmt.Errorf("the procedure record %s does not exist", id)
+    }
+
+    var procedure ProcedureRecord
+    err = json.Unmarshal(procedureJSON, &procedure)
+    if err != nil {
+        return nil, err
+    }
+
+    return &procedure, nil
+}
+
+// hasProcedureAccess checks the caller's grant against the transaction timestamp. It
+// never writes: expired grants grant nothing and are removed lazily by the next
+// GrantAccess or RevokeAccess on the record.
+func hasProcedureAccess(ctx contractapi.TransactionContextInterface, procedure *ProcedureRecord, mspID string, clientID string, purpose string) (bool, error) {
+    if procedure.RecorderMSP == mspID && procedure.Recorder == clientID {
+        return true, nil
+    }
+
+    grant, err := readGrant(ctx, procedure.ID, mspID, clientID)
+    if err != nil || grant == nil {
+        return false, err
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return false, err
+    }
+    if !now.Before(grant.ExpiresAt) {
+        return false, nil
+    }
+
+    return grant.Purpose == purpose, nil
+}
+
+// purgeExpiredGrants deletes the grants on a procedure record that expired by now. Reads
+// never write, so this runs on the grant write paths instead.
+func purgeExpiredGrants(ctx contractapi.TransactionContextInterface, id string, now time.Time) error {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(procedureAccessObjectType, []string{id})
+    if err != nil {
+        return err
+    }
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+
+        var grant ProcedureGrant
+        err = json.Unmarshal(queryResponse.Value, &grant)
+        if err != nil {
+            return err
+        }
+        if now.Before(grant.ExpiresAt) {
+            continue
+        }
+        if err := deleteGrant(ctx, &grant); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func readGrant(ctx contractapi.TransactionContextInterface, id string, granteeMSP string, granteeID string) (*ProcedureGrant, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(procedureAccessObjectType, []string{id, granteeMSP, granteeID})
+    if err != nil {
+        return nil, err
+    }
+    grantJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read access grant from world state: %v", err)
+    }
+    if grantJSON == nil {
+        return nil, nil
+    }
+
+    var grant ProcedureGrant
+    err = json.Unmarshal(grantJSON, &grant)
+    if err != nil {
+        return nil, err
+    }
+
+    return &grant, nil
+}
+
+func deleteGrant(ctx contractapi.TransactionContextInterface, grant *ProcedureGrant) error {
+    key, err := ctx.GetStub().CreateCompositeKey(procedureAccessObjectType, []string{grant.ProcedureID, grant.GranteeMSP, grant.GranteeID})
+    if err != nil {
+        return err
+    }
+    err = ctx.GetStub().DelState(key)
+    if err != nil {
+        return err
+    }
+
+    granteeKey, err := ctx.GetStub().CreateCompositeKey(procedureGranteeObjectType, []string{grant.GranteeMSP, grant.GranteeID, grant.ProcedureID})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(granteeKey)
+}
+
+func requireRecorder(ctx contractapi.TransactionContextInterface, procedure *ProcedureRecord) error {
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    if procedure.RecorderMSP != mspID || procedure.Recorder != clientID {
+        return fmt.Errorf("caller %s of %s is not the recorder of procedure record %s", clientID, mspID, procedure.ID)
+    }
+    return nil
+}
+
+func callerIdentity(ctx contractapi.TransactionContextInterface) (string, string, error) {
+    mspID, err := ctx.GetClientIdentity().GetMSPID()
+    if err != nil {
+        return "", "", fmt.Errorf("failed to read client MSP ID: %v", err)
+    }
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return "", "", fmt.Errorf("failed to read client identity: %v", err)
+    }
+    return mspID, clientID, nil
+}
+
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+    ts, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+    }
+    return ptypes.Timestamp(ts)
+}
@@ -0,0 +1,160 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    accessReceiptObjectType = "AccessReceipt"
+
+    // receiptReadWindow is how long after its receipt a grantee can fetch the record.
+    receiptReadWindow = 10 * time.Minute
+)
+
+type AccessReceipt struct {
+    ReceiptID string    `json:"receiptId"`
+    RecordID  string    `json:"recordId"`
+    ReaderMSP string    `json:"readerMsp"`
+    ReaderID  string    `json:"readerId"`
+    Purpose   string    `json:"purpose"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+// RequestProcedureRead records an access receipt for a grantee's read of a procedure
+// record and returns the receipt ID. It returns no content: the record is fetched with
+// AuditedReadProcedure once the receipt has been committed, so a read cannot happen
+// without a receipt on the ledger.
+func (f *SmartContract) RequestProcedureRead(ctx contractapi.TransactionContextInterface, id string, purpose string) (string, error) {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return "", err
+    }
+
+    readerMSP, readerID, err := callerIdentity(ctx)
+    if err != nil {
+        return "", err
+    }
+    allowed, err := hasProcedureAccess(ctx, procedure, readerMSP, readerID, purpose)
+    if err != nil {
+        return "", err
+    }
+    if !allowed {
+        return "", fmt.Errorf("access to procedure record %s for purpose %q denied", id, purpose)
+    }
+    timestamp, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    receipt := AccessReceipt{
+        ReceiptID: ctx.GetStub().GetTxID(),
+        RecordID:  id,
+        ReaderMSP: readerMSP,
+        ReaderID:  readerID,
+        Purpose:   purpose,
+        Timestamp: timestamp,
+    }
+
+    receiptJSON, err := json.Marshal(receipt)
+    if err != nil {
+        return "", err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(accessReceiptObjectType, []string{id, receipt.ReceiptID})
+    if err != nil {
+        return "", err
+    }
+    err = ctx.GetStub().PutState(key, receiptJSON)
+    if err != nil {
+        return "", fmt.Errorf("failed to write access receipt: %v", err)
+    }
+
+    return receipt.ReceiptID, nil
+}
+
+// AuditedReadProcedure returns a procedure record to the reader named on a committed
+// access receipt, within receiptReadWindow of the receipt and while the reader's grant
+// for the receipt's purpose is still valid. It is evaluated rather than submitted, so it
+// writes nothing: one receipt covers any number of reads in its window, and the ledger
+// counts read sessions rather than individual reads.
+func (f *SmartContract) AuditedReadProcedure(ctx contractapi.TransactionContextInterface, id string, receiptID string) (*ProcedureRecord, error) {
+    procedure, err := f.readProcedure(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(accessReceiptObjectType, []string{id, receiptID})
+    if err != nil {
+        return nil, err
+    }
+    receiptJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read access receipt from world state: %v", err)
+    }
+    if receiptJSON == nil {
+        return nil, fmt.Errorf("no committed access receipt %s exists for procedure record %s", receiptID, id)
+    }
+    var receipt AccessReceipt
+    err = json.Unmarshal(receiptJSON, &receipt)
+    if err != nil {
+        return nil, err
+    }
+
+    readerMSP, readerID, err := callerIdentity(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if receipt.ReaderMSP != readerMSP || receipt.ReaderID != readerID {
+        return nil, fmt.Errorf("access receipt %s was issued to another reader", receiptID)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if now.Before(receipt.Timestamp) || now.After(receipt.Timestamp.Add(receiptReadWindow)) {
+        return nil, fmt.Errorf("access receipt %s is only valid until %s", receiptID, receipt.Timestamp.Add(receiptReadWindow).Format(time.RFC3339))
+    }
+
+    allowed, err := hasProcedureAccess(ctx, procedure, readerMSP, readerID, receipt.Purpose)
+    if err != nil {
+        return nil, err
+    }
+    if !allowed {
+        return nil, fmt.Errorf("access to procedure record %s for purpose %q denied", id, receipt.Purpose)
+    }
+
+    return procedure, nil
+}
+
+func (f *SmartContract) GetAccessReceipts(ctx contractapi.TransactionContextInterface, recordID string) ([]*AccessReceipt, error) {
+    if _, err := f.readProcedure(ctx, recordID); err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accessReceiptObjectType, []string{recordID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var receipts []*AccessReceipt
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var receipt AccessReceipt
+        err = json.Unmarshal(queryResponse.Value, &receipt)
+        if err != nil {
+            return nil, err
+        }
+        receipts = append(receipts, &receipt)
+    }
+
+    return receipts, nil
+}
@@ -0,0 +1,174 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const schemaObjectType = "Schema"
+
+type PayloadSchema struct {
+    ID           string    `json:"id"`
+    Version      uint64    `json:"version"`
+    Schema       string    `json:"schema"`
+    OwnerMSP     string    `json:"ownerMsp"`
+    RegisteredBy string    `json:"registeredBy"`
+    RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// RegisterSchema stores a new version of the schema for a record category and returns
+// its version number. The first org to register a category owns it; later versions can
+// only be registered by members of that org.
+func (f *SmartContract) RegisterSchema(ctx contractapi.TransactionContextInterface, schemaID string, schema string) (uint64, error) {
+    if schemaID == "" {
+        return 0, fmt.Errorf("schema ID must not be empty")
+    }
+    if _, err := compileSchema([]byte(schema)); err != nil {
+        return 0, fmt.Errorf("invalid schema %s: %v", schemaID, err)
+    }
+
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    latest, err := f.latestSchema(ctx, schemaID)
+    if err != nil {
+        return 0, err
+    }
+    version := uint64(1)
+    if latest != nil {
+        if latest.OwnerMSP != mspID {
+            return 0, fmt.Errorf("schema %s is owned by %s, not %s", schemaID, latest.OwnerMSP, mspID)
+        }
+        version = latest.Version + 1
+    }
+
+    registeredAt, err := txTimestamp(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    record := PayloadSchema{
+        ID:           schemaID,
+        Version:      version,
+        Schema:       schema,
+        OwnerMSP:     mspID,
+        RegisteredBy: clientID,
+        RegisteredAt: registeredAt,
+    }
+
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return 0, err
+    }
+
+    key, err := schemaKey(ctx, schemaID, version)
+    if err != nil {
+        return 0, err
+    }
+
+    return version, ctx.GetStub().PutState(key, recordJSON)
+}
+
+// GetSchema returns the given version of a schema, or the latest one when version is 0.
+func (f *SmartContract) GetSchema(ctx contractapi.TransactionContextInterface, schemaID string, version uint64) (*PayloadSchema, error) {
+    if version == 0 {
+        latest, err := f.latestSchema(ctx, schemaID)
+        if err != nil {
+            return nil, err
+        }
+        if latest == nil {
+            return nil, fmt.Errorf("the schema %s does not exist", schemaID)
+        }
+        return latest, nil
+    }
+
+    key, err := schemaKey(ctx, schemaID, version)
+    if err != nil {
+        return nil, err
+    }
+    recordJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read schema from world state: %v", err)
+    }
+    if recordJSON == nil {
+        return nil, fmt.Errorf("the schema %s version %d does not exist", schemaID, version)
+    }
+
+    var record PayloadSchema
+    err = json.Unmarshal(recordJSON, &record)
+    if err != nil {
+        return nil, err
+    }
+
+    return &record, nil
+}
+
+func (f *SmartContract) GetSchemaVersions(ctx contractapi.TransactionContextInterface, schemaID string) ([]*PayloadSchema, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(schemaObjectType, []string{schemaID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var schemas []*PayloadSchema
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var record PayloadSchema
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        schemas = append(schemas, &record)
+    }
+
+    return schemas, nil
+}
+
+func (f *SmartContract) latestSchema(ctx contractapi.TransactionContextInterface, schemaID string) (*PayloadSchema, error) {
+    schemas, err := f.GetSchemaVersions(ctx, schemaID)
+    if err != nil {
+        return nil, err
+    }
+    if len(schemas) == 0 {
+        return nil, nil
+    }
+    return schemas[len(schemas)-1], nil
+}
+
+// validatePayload checks payload against the referenced schema and returns the version
+// that was applied, so the record can store exactly which schema it conforms to.
+func (f *SmartContract) validatePayload(ctx contractapi.TransactionContextInterface, schemaID string, version uint64, payload []byte) (uint64, error) {
+    record, err := f.GetSchema(ctx, schemaID, version)
+    if err != nil {
+        return 0, err
+    }
+
+    schema, err := compileSchema([]byte(record.Schema))
+    if err != nil {
+        return 0, fmt.Errorf("stored schema %s version %d is invalid: %v", schemaID, record.Version, err)
+    }
+
+    document, err := decodeJSON(payload)
+    if err != nil {
+        return 0, fmt.Errorf("payload is not valid JSON: %v", err)
+    }
+    if err := schema.validate(document, "$"); err != nil {
+        return 0, fmt.Errorf("payload does not conform to schema %s version %d: %v", schemaID, record.Version, err)
+    }
+
+    return record.Version, nil
+}
+
+// schemaKey zero-pads the version so that versions are returned in numeric order.
+func schemaKey(ctx contractapi.TransactionContextInterface, schemaID string, version uint64) (string, error) {
+    return ctx.GetStub().CreateCompositeKey(schemaObjectType, []string{schemaID, fmt.Sprintf("%020d", version)})
+}
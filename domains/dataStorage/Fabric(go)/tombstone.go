@@ -0,0 +1,109 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+type Tombstone struct {
+    DeletedBy    string    `json:"deletedBy"`
+    DeletedByMSP string    `json:"deletedByMsp"`
+    DeletedAt    time.Time `json:"deletedAt"`
+    Reason       string    `json:"reason"`
+}
+
+func newTombstone(ctx contractapi.TransactionContextInterface, reason string) (*Tombstone, error) {
+    mspID, clientID, err := callerIdentity(ctx)
+    if err != nil {
+        return nil, err
+    }
+    deletedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Tombstone{
+        DeletedBy:    clientID,
+        DeletedByMSP: mspID,
+        DeletedAt:    deletedAt,
+        Reason:       reason,
+    }, nil
+}
+
+// RestoreCrop brings back the most recent revision of a deleted crop record that was not
+// itself a tombstone. The restored record gets a new version so stale updates still fail.
+func (f *SmartContract) RestoreCrop(ctx contractapi.TransactionContextInterface, id string) (*CropRecord, error) {
+    current, err := f.readCrop(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if current.Deleted == nil {
+        return nil, fmt.Errorf("the crop record %s is not deleted", id)
+    }
+
+    resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var restored *CropRecord
+    var restoredAt time.Time
+    for resultsIterator.HasNext() {
+        response, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        if response.IsDelete || len(response.Value) == 0 {
+            continue
+        }
+
+        var crop CropRecord
+        err = json.Unmarshal(response.Value, &crop)
+        if err != nil {
+            return nil, err
+        }
+        if crop.Deleted != nil {
+            continue
+        }
+
+        timestamp, err := ptypes.Timestamp(response.Timestamp)
+        if err != nil {
+            return nil, err
+        }
+        if restored == nil || timestamp.After(restoredAt) {
+            restored = &crop
+            restoredAt = timestamp
+        }
+    }
+
+    if restored == nil {
+        return nil, fmt.Errorf("the crop record %s has no live revision to restore", id)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+    restored.Timestamp = now.String()
+    restored.Version = current.Version + 1
+    // Personal data is not versioned with the record; never resurrect an erased hash.
+    restored.PersonalHash = current.PersonalHash
+    restored.ErasureID = current.ErasureID
+
+    cropJSON, err := json.Marshal(restored)
+    if err != nil {
+        return nil, err
+    }
+
+    err = ctx.GetStub().PutState(id, cropJSON)
+    if err != nil {
+        return nil, err
+    }
+
+    return restored, nil
+}
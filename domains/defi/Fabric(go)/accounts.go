@@ -0,0 +1,226 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    accountObjectType         = "Account"
+    accountIdentityObjectType = "AccountIdentity"
+
+    // accountAttribute is the enrollment attribute through which an organisation's CA
+    // can bind its identities to an account without registering each certificate.
+    accountAttribute = "agri.account"
+)
+
+// Account is a farmer or other participant whose crop balance, tokens, loans and orders
+// are keyed by ID. An account belongs to exactly one MSP, and only identities of that
+// MSP can act for it.
+type Account struct {
+    ID           string    `json:"id"`
+    MSPID        string    `json:"mspId"`
+    RegisteredBy string    `json:"registeredBy"`
+    RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// AccountIdentity maps one certificate identity to the account it acts for.
+type AccountIdentity struct {
+    MSPID        string    `json:"mspId"`
+    ClientID     string    `json:"clientId"`
+    Account      string    `json:"account"`
+    RegisteredBy string    `json:"registeredBy"`
+    RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// RegisterAccount creates the account if it does not exist yet and, if clientID is not
+// empty, maps that identity of mspID to it. Identities whose certificates carry the
+// agri.account attribute need no mapping, but the account must still be registered to
+// their MSP.
+func (s *SmartContract) RegisterAccount(ctx contractapi.TransactionContextInterface, account string, mspID string, clientID string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    if account == "" || mspID == "" {
+        return fmt.Errorf("account and MSP ID must not be empty")
+    }
+    if strings.Contains(account, ":") {
+        return fmt.Errorf("account %q must not contain ':', which names escrows", account)
+    }
+
+    registeredBy, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to read client identity: %v", err)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    existing, err := readAccount(ctx, account)
+    if err != nil {
+        return err
+    }
+    if existing == nil {
+        accountJSON, err := json.Marshal(Account{ID: account, MSPID: mspID, RegisteredBy: registeredBy, RegisteredAt: now})
+        if err != nil {
+            return err
+        }
+        key, err := ctx.GetStub().CreateCompositeKey(accountObjectType, []string{account})
+        if err != nil {
+            return err
+        }
+        if err := ctx.GetStub().PutState(key, accountJSON); err != nil {
+            return err
+        }
+    } else if existing.MSPID != mspID {
+        return fmt.Errorf("account %s belongs to %s", account, existing.MSPID)
+    }
+
+    if clientID == "" {
+        return nil
+    }
+    mapped, err := readAccountIdentity(ctx, mspID, clientID)
+    if err != nil {
+        return err
+    }
+    if mapped != nil && mapped.Account != account {
+        return fmt.Errorf("the identity is already registered to account %s", mapped.Account)
+    }
+
+    identityJSON, err := json.Marshal(AccountIdentity{MSPID: mspID, ClientID: clientID, Account: account, RegisteredBy: registeredBy, RegisteredAt: now})
+    if err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(accountIdentityObjectType, []string{mspID, clientID})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, identityJSON)
+}
+
+func (s *SmartContract) RemoveAccountIdentity(ctx contractapi.TransactionContextInterface, mspID string, clientID string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(accountIdentityObjectType, []string{mspID, clientID})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func (s *SmartContract) GetAccount(ctx contractapi.TransactionContextInterface, account string) (*Account, error) {
+    existing, err := readAccount(ctx, account)
+    if err != nil {
+        return nil, err
+    }
+    if existing == nil {
+        return nil, fmt.Errorf("account %s does not exist", account)
+    }
+    return existing, nil
+}
+
+// GetCallerAccount returns the account the submitting identity acts for.
+func (s *SmartContract) GetCallerAccount(ctx contractapi.TransactionContextInterface) (string, error) {
+    return callerAccount(ctx)
+}
+
+// callerAccount resolves the submitting identity to its account: first through an
+// explicit identity mapping, then through the agri.account enrollment attribute, which
+// is only trusted for accounts registered to the caller's own MSP.
+func callerAccount(ctx contractapi.TransactionContextInterface) (string, error) {
+    mspID, err := ctx.GetClientIdentity().GetMSPID()
+    if err != nil {
+        return "", fmt.Errorf("failed to read client MSP ID: %v", err)
+    }
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return "", fmt.Errorf("failed to read client identity: %v", err)
+    }
+
+    mapped, err := readAccountIdentity(ctx, mspID, clientID)
+    if err != nil {
+        return "", err
+    }
+    if mapped != nil {
+        return mapped.Account, nil
+    }
+
+    account, found, err := ctx.GetClientIdentity().GetAttributeValue(accountAttribute)
+    if err != nil {
+        return "", fmt.Errorf("failed to read %s attribute: %v", accountAttribute, err)
+    }
+    if !found || account == "" {
+        return "", fmt.Errorf("the caller is not registered to an account")
+    }
+    existing, err := readAccount(ctx, account)
+    if err != nil {
+        return "", err
+    }
+    if existing == nil || existing.MSPID != mspID {
+        return "", fmt.Errorf("account %s is not registered to %s", account, mspID)
+    }
+
+    return account, nil
+}
+
+// requireAccount rejects operations on an account the caller does not act for.
+func requireAccount(ctx contractapi.TransactionContextInterface, account string) error {
+    caller, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if caller != account {
+        return fmt.Errorf("the caller acts for %s and cannot operate on the account of %s", caller, account)
+    }
+    return nil
+}
+
+func readAccount(ctx contractapi.TransactionContextInterface, account string) (*Account, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(accountObjectType, []string{account})
+    if err != nil {
+        return nil, err
+    }
+    accountJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read account from world state: %v", err)
+    }
+    if accountJSON == nil {
+        return nil, nil
+    }
+
+    var existing Account
+    err = json.Unmarshal(accountJSON, &existing)
+    if err != nil {
+        return nil, err
+    }
+
+    return &existing, nil
+}
+
+func readAccountIdentity(ctx contractapi.TransactionContextInterface, mspID string, clientID string) (*AccountIdentity, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(accountIdentityObjectType, []string{mspID, clientID})
+    if err != nil {
+        return nil, err
+    }
+    identityJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read account identity from world state: %v", err)
+    }
+    if identityJSON == nil {
+        return nil, nil
+    }
+
+    var identity AccountIdentity
+    err = json.Unmarshal(identityJSON, &identity)
+    if err != nil {
+        return nil, err
+    }
+
+    return &identity, nil
+}
@@ -0,0 +1,201 @@
+package chaincode
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "math"
+    "math/big"
+    "strings"
+)
+
+// AmountScale is the number of decimal places an Amount carries. It is part of the
+// ledger encoding, so changing it requires migrating existing balances.
+const AmountScale = 6
+
+var amountUnit = int64(math.Pow10(AmountScale))
+
+// Amount is a non-negative fixed-point decimal, stored and passed as a string with
+// exactly AmountScale decimal places, e.g. "12.500000". All arithmetic is done on
+// integer units of 10^-AmountScale and fails instead of overflowing or going negative.
+// The empty Amount is zero.
+type Amount string
+
+var zeroAmount = formatUnits(0)
+
+// ParseAmount accepts a plain decimal with at most AmountScale decimal places and
+// returns it in canonical form. Inputs that would need rounding are rejected.
+func ParseAmount(text string) (Amount, error) {
+    units, err := parseUnits(text)
+    if err != nil {
+        return "", err
+    }
+    return formatUnits(units), nil
+}
+
+// requirePositive validates an amount passed in by a client.
+func requirePositive(amount Amount, what string) (Amount, error) {
+    units, err := amount.Units()
+    if err != nil {
+        return "", fmt.Errorf("invalid %s: %v", what, err)
+    }
+    if units == 0 {
+        return "", fmt.Errorf("%s must be greater than zero", what)
+    }
+    return formatUnits(units), nil
+}
+
+func (a Amount) Units() (int64, error) {
+    return parseUnits(string(a))
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+    x, y, err := bothUnits(a, b)
+    if err != nil {
+        return "", err
+    }
+    if x > math.MaxInt64-y {
+        return "", fmt.Errorf("amount overflow adding %s and %s", a, b)
+    }
+    return formatUnits(x + y), nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+    x, y, err := bothUnits(a, b)
+    if err != nil {
+        return "", err
+    }
+    if y > x {
+        return "", fmt.Errorf("cannot subtract %s from %s", b, a)
+    }
+    return formatUnits(x - y), nil
+}
+
+func (a Amount) Cmp(b Amount) (int, error) {
+    x, y, err := bothUnits(a, b)
+    if err != nil {
+        return 0, err
+    }
+    switch {
+    case x < y:
+        return -1, nil
+    case x > y:
+        return 1, nil
+    }
+    return 0, nil
+}
+
+// MulDiv returns a*num/den, truncated toward zero. The intermediate product is exact,
+// so rates and time fractions can be applied in one step without losing precision.
+func (a Amount) MulDiv(num int64, den int64) (Amount, error) {
+    x, err := a.Units()
+    if err != nil {
+        return "", err
+    }
+    if num < 0 || den <= 0 {
+        return "", fmt.Errorf("invalid factor %d/%d", num, den)
+    }
+
+    product := new(big.Int).Mul(big.NewInt(x), big.NewInt(num))
+    product.Quo(product, big.NewInt(den))
+    if !product.IsInt64() {
+        return "", fmt.Errorf("amount overflow multiplying %s by %d/%d", a, num, den)
+    }
+    return formatUnits(product.Int64()), nil
+}
+
+// Ratio reports a/b as a float for display, such as yield ratios. It is never used to
+// compute amounts.
+func (a Amount) Ratio(b Amount) (float64, error) {
+    x, y, err := bothUnits(a, b)
+    if err != nil {
+        return 0, err
+    }
+    if y == 0 {
+        return 0, nil
+    }
+    return float64(x) / float64(y), nil
+}
+
+// UnmarshalJSON accepts the canonical string form and, for state written before
+// amounts were fixed-point, a JSON number. Numbers are rounded half away from zero to
+// AmountScale places, which removes binary floating-point noise such as 0.1+0.2.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+    data = bytes.TrimSpace(data)
+    if len(data) > 0 && data[0] == '"' {
+        var text string
+        if err := json.Unmarshal(data, &text); err != nil {
+            return err
+        }
+        parsed, err := ParseAmount(text)
+        if err != nil {
+            return err
+        }
+        *a = parsed
+        return nil
+    }
+
+    value, ok := new(big.Rat).SetString(string(data))
+    if !ok {
+        return fmt.Errorf("invalid amount %s", data)
+    }
+    if value.Sign() < 0 {
+        return fmt.Errorf("amount %s must not be negative", data)
+    }
+    value.Mul(value, new(big.Rat).SetInt64(amountUnit))
+    value.Add(value, big.NewRat(1, 2))
+    units := new(big.Int).Quo(value.Num(), value.Denom())
+    if !units.IsInt64() {
+        return fmt.Errorf("amount %s is out of range", data)
+    }
+    *a = formatUnits(units.Int64())
+    return nil
+}
+
+func bothUnits(a Amount, b Amount) (int64, int64, error) {
+    x, err := a.Units()
+    if err != nil {
+        return 0, 0, err
+    }
+    y, err := b.Units()
+    if err != nil {
+        return 0, 0, err
+    }
+    return x, y, nil
+}
+
+func parseUnits(text string) (int64, error) {
+    if text == "" {
+        return 0, nil
+    }
+
+    whole, fraction, hasPoint := strings.Cut(text, ".")
+    if whole == "" || (hasPoint && fraction == "") {
+        return 0, fmt.Errorf("invalid amount %q", text)
+    }
+    if len(fraction) > AmountScale {
+        return 0, fmt.Errorf("amount %q has more than %d decimal places", text, AmountScale)
+    }
+    fraction += strings.Repeat("0", AmountScale-len(fraction))
+
+    var units int64
+    for _, r := range whole + fraction {
+        if r < '0' || r > '9' {
+            return 0, fmt.Errorf("invalid amount %q", text)
+        }
+        digit := int64(r - '0')
+        if units > (math.MaxInt64-digit)/10 {
+            return 0, fmt.Errorf("amount %q is out of range", text)
+        }
+        units = units*10 + digit
+    }
+
+    return units, nil
+}
+
+func formatUnits(units int64) Amount {
+    if AmountScale == 0 {
+        return Amount(fmt.Sprintf("%d", units))
+    }
+    return Amount(fmt.Sprintf("%d.%0*d", units/amountUnit, AmountScale, units%amountUnit))
+}
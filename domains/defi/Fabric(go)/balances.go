@@ -0,0 +1,136 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// moveCrops transfers amount between two balances, creating the recipient's balance if
+// needed. It backs every internal transfer that DistributeCrops does not cover.
+func (s *SmartContract) moveCrops(ctx contractapi.TransactionContextInterface, from string, to string, amount Amount, now time.Time) error {
+    if err := s.debitCrops(ctx, from, amount, now); err != nil {
+        return err
+    }
+    return s.creditCrops(ctx, to, amount, now)
+}
+
+func (s *SmartContract) debitCrops(ctx contractapi.TransactionContextInterface, farmer string, amount Amount, now time.Time) error {
+    return s.unlockAndDebitCrops(ctx, farmer, zeroAmount, amount, now)
+}
+
+// unlockAndDebitCrops releases unlock from the farmer's locked crops and debits amount in
+// a single write. A transaction does not read its own writes, so unlocking and debiting
+// the same balance in two steps would lose the unlock.
+func (s *SmartContract) unlockAndDebitCrops(ctx contractapi.TransactionContextInterface, farmer string, unlock Amount, amount Amount, now time.Time) error {
+    crops, err := s.GetCropBalance(ctx, farmer)
+    if err != nil {
+        return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
+    }
+    if unlock != zeroAmount {
+        crops.Locked, err = crops.Locked.Sub(unlock)
+        if err != nil {
+            return fmt.Errorf("%s has less than %s crops locked", farmer, unlock)
+        }
+    }
+    cmp, err := crops.compareAvailable(amount)
+    if err != nil {
+        return err
+    }
+    if cmp < 0 {
+        return fmt.Errorf("%s doesn't have enough crops", farmer)
+    }
+
+    crops.CropAmount, err = crops.CropAmount.Sub(amount)
+    if err != nil {
+        return err
+    }
+    crops.Timestamp = now.String()
+    return putCropBalance(ctx, crops)
+}
+
+func (s *SmartContract) creditCrops(ctx contractapi.TransactionContextInterface, farmer string, amount Amount, now time.Time) error {
+    crops, err := s.GetCropBalance(ctx, farmer)
+    if err != nil {
+        crops = &CropBalance{Farmer: farmer, CropAmount: zeroAmount}
+    }
+
+    crops.CropAmount, err = crops.CropAmount.Add(amount)
+    if err != nil {
+        return err
+    }
+    crops.Timestamp = now.String()
+    return putCropBalance(ctx, crops)
+}
+
+// Available is the part of the balance not locked as loan collateral. Only available
+// crops can be distributed, planted, discarded or otherwise spent.
+func (c *CropBalance) Available() (Amount, error) {
+    return c.CropAmount.Sub(c.Locked)
+}
+
+func (c *CropBalance) compareAvailable(amount Amount) (int, error) {
+    available, err := c.Available()
+    if err != nil {
+        return 0, err
+    }
+    return available.Cmp(amount)
+}
+
+func (s *SmartContract) lockCrops(ctx contractapi.TransactionContextInterface, farmer string, amount Amount, now time.Time) error {
+    crops, err := s.GetCropBalance(ctx, farmer)
+    if err != nil {
+        return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
+    }
+    cmp, err := crops.compareAvailable(amount)
+    if err != nil {
+        return err
+    }
+    if cmp < 0 {
+        return fmt.Errorf("%s doesn't have enough unlocked crops", farmer)
+    }
+
+    crops.Locked, err = crops.Locked.Add(amount)
+    if err != nil {
+        return err
+    }
+    crops.Timestamp = now.String()
+    return putCropBalance(ctx, crops)
+}
+
+func (s *SmartContract) unlockCrops(ctx contractapi.TransactionContextInterface, farmer string, amount Amount, now time.Time) error {
+    crops, err := s.GetCropBalance(ctx, farmer)
+    if err != nil {
+        return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
+    }
+
+    crops.Locked, err = crops.Locked.Sub(amount)
+    if err != nil {
+        return fmt.Errorf("%s has less than %s crops locked", farmer, amount)
+    }
+    crops.Timestamp = now.String()
+    return putCropBalance(ctx, crops)
+}
+
+// storedCropBalance is a CropBalance as written to the world state. cropAmountKey only
+// exists in storage, for rich queries; reads decode into CropBalance and drop it.
+type storedCropBalance struct {
+    *CropBalance
+    CropAmountKey string `json:"cropAmountKey"`
+}
+
+// putCropBalance is the only writer of crop balances, so the shadow key always matches
+// cropAmount.
+func putCropBalance(ctx contractapi.TransactionContextInterface, crops *CropBalance) error {
+    key, err := amountKey(crops.CropAmount)
+    if err != nil {
+        return err
+    }
+    cropJSON, err := json.Marshal(storedCropBalance{CropBalance: crops, CropAmountKey: key})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(crops.Farmer, cropJSON)
+}
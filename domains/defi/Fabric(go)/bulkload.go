@@ -0,0 +1,76 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    // adminAttribute is the enrollment attribute that marks an identity as allowed to
+    // perform privileged operations such as bulk loads.
+    adminAttribute = "agri.admin"
+
+    // maxBulkLoadRecords bounds the size of a single BulkLoad transaction's write set.
+    maxBulkLoadRecords = 500
+)
+
+// BulkLoad writes crop balances exported by the snapshot tool, exactly as exported, into
+// an empty or partially loaded ledger. It is restricted to admins and refuses to
+// overwrite any balance that already exists. Loans are not part of a snapshot, so a
+// balance with locked collateral is rejected rather than loaded with nothing to unlock it.
+func (s *SmartContract) BulkLoad(ctx contractapi.TransactionContextInterface, recordsJSON string) (int, error) {
+    if err := requireAdmin(ctx); err != nil {
+        return 0, err
+    }
+
+    var records []CropBalance
+    if err := json.Unmarshal([]byte(recordsJSON), &records); err != nil {
+        return 0, fmt.Errorf("invalid bulk load records: %v", err)
+    }
+    if len(records) == 0 || len(records) > maxBulkLoadRecords {
+        return 0, fmt.Errorf("bulk load takes 1 to %d records, got %d", maxBulkLoadRecords, len(records))
+    }
+
+    seen := make(map[string]bool, len(records))
+    for i, record := range records {
+        if record.Farmer == "" {
+            return 0, fmt.Errorf("bulk load balance without a farmer")
+        }
+        if seen[record.Farmer] {
+            return 0, fmt.Errorf("the balance of %s appears more than once in the batch", record.Farmer)
+        }
+        seen[record.Farmer] = true
+        locked, err := record.Locked.Units()
+        if err != nil {
+            return 0, fmt.Errorf("invalid locked amount for %s: %v", record.Farmer, err)
+        }
+        if locked != 0 {
+            return 0, fmt.Errorf("the balance of %s has %s locked as loan collateral, which a snapshot cannot carry", record.Farmer, record.Locked)
+        }
+
+        existing, err := ctx.GetStub().GetState(record.Farmer)
+        if err != nil {
+            return 0, fmt.Errorf("failed to read crops from world state: %v", err)
+        }
+        if existing != nil {
+            return 0, fmt.Errorf("a crop balance for %s already exists", record.Farmer)
+        }
+
+        err = putCropBalance(ctx, &records[i])
+        if err != nil {
+            return 0, fmt.Errorf("failed to put crops for %s to world state: %v", record.Farmer, err)
+        }
+    }
+
+    return len(records), nil
+}
+
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+    err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true")
+    if err != nil {
+        return fmt.Errorf("the caller is not authorised for this operation: %v", err)
+    }
+    return nil
+}
@@ -0,0 +1,202 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    cropPriceObjectType = "CropPrice"
+
+    // maxLoanToValueBasisPoints is the highest debt-to-collateral-value ratio at which a
+    // collateralised loan can be opened or approved.
+    maxLoanToValueBasisPoints = 7000
+
+    // maintenanceBasisPoints is the ratio above which anyone may liquidate the loan.
+    maintenanceBasisPoints = 8500
+)
+
+// CropPrice is the value of one unit of a crop type, expressed in the crop balance
+// units that loans are made in.
+type CropPrice struct {
+    CropType  string    `json:"cropType"`
+    Price     Amount    `json:"price"`
+    UpdatedBy string    `json:"updatedBy"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (s *SmartContract) SetCropPrice(ctx contractapi.TransactionContextInterface, cropType string, price Amount) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    price, err := requirePositive(price, "crop price")
+    if err != nil {
+        return err
+    }
+    updatedBy, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to read client identity: %v", err)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    priceJSON, err := json.Marshal(CropPrice{CropType: cropType, Price: price, UpdatedBy: updatedBy, UpdatedAt: now})
+    if err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(cropPriceObjectType, []string{cropType})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, priceJSON)
+}
+
+func (s *SmartContract) GetCropPrice(ctx contractapi.TransactionContextInterface, cropType string) (*CropPrice, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(cropPriceObjectType, []string{cropType})
+    if err != nil {
+        return nil, err
+    }
+    priceJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read crop price from world state: %v", err)
+    }
+    if priceJSON == nil {
+        return nil, fmt.Errorf("no price is set for %s", cropType)
+    }
+
+    var price CropPrice
+    err = json.Unmarshal(priceJSON, &price)
+    if err != nil {
+        return nil, err
+    }
+
+    return &price, nil
+}
+
+// AttachCollateral locks part of the farmer's balance against a requested loan. The
+// locked crops are valued as cropType at the on-ledger price and must keep the loan
+// within the maximum loan-to-value ratio.
+func (s *SmartContract) AttachCollateral(ctx contractapi.TransactionContextInterface, loanID string, cropType string, amount Amount) error {
+    amount, err := requirePositive(amount, "collateral amount")
+    if err != nil {
+        return err
+    }
+
+    loan, err := s.GetLoan(ctx, loanID)
+    if err != nil {
+        return err
+    }
+    if err := requireAccount(ctx, loan.Farmer); err != nil {
+        return err
+    }
+    if loan.Status != loanStatusRequested {
+        return fmt.Errorf("loan %s is not in a requested state", loanID)
+    }
+    if loan.Collateral != "" {
+        return fmt.Errorf("loan %s already has collateral attached", loanID)
+    }
+
+    loan.CollateralCropType = cropType
+    loan.Collateral = amount
+    ok, err := s.withinLoanToValue(ctx, loan, loan.Amount, maxLoanToValueBasisPoints)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        return fmt.Errorf("collateral of %s %s does not cover loan %s at %d basis points loan-to-value", amount, cropType, loanID, maxLoanToValueBasisPoints)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if err := s.lockCrops(ctx, loan.Farmer, amount, now); err != nil {
+        return err
+    }
+
+    return putLoan(ctx, loan, "CollateralAttached")
+}
+
+// LiquidateLoan can be called by anyone once the farmer's debt, including accrued
+// interest, exceeds the maintenance ratio of the collateral's current value. The whole
+// collateral goes to the lender and the loan is closed.
+func (s *SmartContract) LiquidateLoan(ctx contractapi.TransactionContextInterface, loanID string) error {
+    loan, err := s.GetLoan(ctx, loanID)
+    if err != nil {
+        return err
+    }
+    if loan.Status != loanStatusApproved || loan.Collateral == "" {
+        return fmt.Errorf("loan %s is not an open collateralised loan", loanID)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    interest, err := accruedInterest(loan, now)
+    if err != nil {
+        return err
+    }
+    debt, err := loan.Amount.Add(interest)
+    if err != nil {
+        return err
+    }
+
+    healthy, err := s.withinLoanToValue(ctx, loan, debt, maintenanceBasisPoints)
+    if err != nil {
+        return err
+    }
+    if healthy {
+        return fmt.Errorf("loan %s is above the maintenance threshold and cannot be liquidated", loanID)
+    }
+
+    liquidator, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if err := s.unlockAndDebitCrops(ctx, loan.Farmer, loan.Collateral, loan.Collateral, now); err != nil {
+        return err
+    }
+    if err := s.creditCrops(ctx, loan.Lender, loan.Collateral, now); err != nil {
+        return err
+    }
+
+    loan.Status = loanStatusLiquidated
+    loan.ClosedAt = now
+    loan.ClosedBy = liquidator
+
+    return putLoan(ctx, loan, "LoanLiquidated")
+}
+
+// withinLoanToValue reports whether debt is at most limitBasisPoints of the value of the
+// loan's collateral at the current price.
+func (s *SmartContract) withinLoanToValue(ctx contractapi.TransactionContextInterface, loan *Loan, debt Amount, limitBasisPoints int64) (bool, error) {
+    price, err := s.GetCropPrice(ctx, loan.CollateralCropType)
+    if err != nil {
+        return false, err
+    }
+    priceUnits, err := price.Price.Units()
+    if err != nil {
+        return false, err
+    }
+
+    value, err := loan.Collateral.MulDiv(priceUnits, amountUnit)
+    if err != nil {
+        return false, err
+    }
+    limit, err := value.MulDiv(limitBasisPoints, basisPoints)
+    if err != nil {
+        return false, err
+    }
+
+    cmp, err := debt.Cmp(limit)
+    if err != nil {
+        return false, err
+    }
+    return cmp <= 0, nil
+}
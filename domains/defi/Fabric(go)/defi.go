@@ -16,15 +16,19 @@ type SmartContract struct {
 
 type CropBalance struct {
     Farmer    string  `json:"farmer"`
-    CropAmount float64 `json:"cropAmount"`
+    CropAmount Amount `json:"cropAmount"`
+    Locked    Amount  `json:"locked,omitempty"`
     Timestamp string  `json:"timestamp"`
 }
 
 type PlantingInfo struct {
+    ID            string  `json:"id"`
     Farmer        string  `json:"farmer"`
-    PlantedAmount float64 `json:"plantedAmount"`
-    Yield         float64 `json:"yield"`
+    PlantedAmount Amount  `json:"plantedAmount"`
+    Yield         Amount  `json:"yield"`
+    Status        string  `json:"status"`
     Timestamp     string  `json:"timestamp"`
+    HarvestedAt   string  `json:"harvestedAt,omitempty"`
 }
 
 type CropHistoryQueryResult struct {
@@ -42,117 +46,169 @@ type PlantingHistoryQueryResult struct {
 }
 
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
     crops := []CropBalance{
         {
             Farmer:    "Farmer1",
-            CropAmount: 1000.0,
-            Timestamp: time.Now().String(),
+            CropAmount: "1000.000000",
+            Timestamp: now.String(),
         },
         {
             Farmer:    "Farmer2",
-            CropAmount: 500.0,
-            Timestamp: time.Now().String(),
+            CropAmount: "500.000000",
+            Timestamp: now.String(),
         },
     }
 
-    for _, crop := range crops {
-        cropJSON, err := json.Marshal(crop)
-        if err != nil {
-            return err
-        }
-
-        err = ctx.GetStub().PutState(crop.Farmer, cropJSON)
+    for i := range crops {
+        err := putCropBalance(ctx, &crops[i])
         if err != nil {
-            return fmt.Errorf("failed to put crops for %s to world state: %v", crop.Farmer, err)
+            return fmt.Errorf("failed to put crops for %s to world state: %v", crops[i].Farmer, err)
         }
     }
 
     return nil
 }
 
-func (s *SmartContract) HarvestCrops(ctx contractapi.TransactionContextInterface, farmer string, amount float64) error {
+// HarvestCrops credits amount to the caller's balance, creating it if needed.
+func (s *SmartContract) HarvestCrops(ctx contractapi.TransactionContextInterface, amount Amount) error {
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    amount, err = requirePositive(amount, "harvested amount")
+    if err != nil {
+        return err
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
     crops, err := s.GetCropBalance(ctx, farmer)
     if err != nil {
         crops = &CropBalance{
             Farmer:    farmer,
-            CropAmount: 0.0,
-            Timestamp: time.Now().String(),
+            CropAmount: zeroAmount,
         }
     }
 
-    crops.CropAmount += amount
-    crops.Timestamp = time.Now().String()
-
-    cropJSON, err := json.Marshal(crops)
+    crops.CropAmount, err = crops.CropAmount.Add(amount)
     if err != nil {
         return err
     }
+    crops.Timestamp = now.String()
 
-    return ctx.GetStub().PutState(farmer, cropJSON)
+    return putCropBalance(ctx, crops)
 }
 
-func (s *SmartContract) DistributeCrops(ctx contractapi.TransactionContextInterface, from, to string, amount float64) error {
+// DistributeCrops moves amount from the caller's balance to to, and counts it towards
+// any forward contracts the caller owes to.
+func (s *SmartContract) DistributeCrops(ctx contractapi.TransactionContextInterface, to string, amount Amount) error {
+    from, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    amount, err = requirePositive(amount, "distributed amount")
+    if err != nil {
+        return err
+    }
+    if to == from {
+        return fmt.Errorf("%s cannot distribute crops to itself", from)
+    }
+
     fromCrops, err := s.GetCropBalance(ctx, from)
     if err != nil {
         return fmt.Errorf("failed to get crops for %s: %v", from, err)
     }
 
-    if fromCrops.CropAmount < amount {
+    cmp, err := fromCrops.compareAvailable(amount)
+    if err != nil {
+        return err
+    }
+    if cmp < 0 {
         return fmt.Errorf("%s doesn't have enough crops", from)
     }
 
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
     toCrops, err := s.GetCropBalance(ctx, to)
     if err != nil {
         toCrops = &CropBalance{
             Farmer:    to,
-            CropAmount: 0.0,
-            Timestamp: time.Now().String(),
+            CropAmount: zeroAmount,
         }
     }
 
-    fromCrops.CropAmount -= amount
-    toCrops.CropAmount += amount
-
-    fromCrops.Timestamp = time.Now().String()
-    toCrops.Timestamp = time.Now().String()
-
-    fromJSON, err := json.Marshal(fromCrops)
+    fromCrops.CropAmount, err = fromCrops.CropAmount.Sub(amount)
     if err != nil {
         return err
     }
+    toCrops.CropAmount, err = toCrops.CropAmount.Add(amount)
+    if err != nil {
+        return err
+    }
+
+    fromCrops.Timestamp = now.String()
+    toCrops.Timestamp = now.String()
 
-    toJSON, err := json.Marshal(toCrops)
+    err = putCropBalance(ctx, fromCrops)
     if err != nil {
         return err
     }
 
-    err = ctx.GetStub().PutState(from, fromJSON)
+    err = putCropBalance(ctx, toCrops)
     if err != nil {
         return err
     }
 
-    return ctx.GetStub().PutState(to, toJSON)
+    return s.applyForwardDeliveries(ctx, from, to, amount)
 }
 
-func (s *SmartContract) DiscardSpoiledCrops(ctx contractapi.TransactionContextInterface, farmer string, amount float64) error {
+// DiscardSpoiledCrops removes amount from the caller's available crops.
+func (s *SmartContract) DiscardSpoiledCrops(ctx contractapi.TransactionContextInterface, amount Amount) error {
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    amount, err = requirePositive(amount, "discarded amount")
+    if err != nil {
+        return err
+    }
+
     crops, err := s.GetCropBalance(ctx, farmer)
     if err != nil {
         return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
     }
 
-    if crops.CropAmount < amount {
+    cmp, err := crops.compareAvailable(amount)
+    if err != nil {
+        return err
+    }
+    if cmp < 0 {
         return fmt.Errorf("%s doesn't have enough crops to discard", farmer)
     }
 
-    crops.CropAmount -= amount
-    crops.Timestamp = time.Now().String()
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
 
-    cropJSON, err := json.Marshal(crops)
+    crops.CropAmount, err = crops.CropAmount.Sub(amount)
     if err != nil {
         return err
     }
+    crops.Timestamp = now.String()
 
-    return ctx.GetStub().PutState(farmer, cropJSON)
+    return putCropBalance(ctx, crops)
 }
 
 func (s *SmartContract) GetCropBalance(ctx contractapi.TransactionContextInterface, farmer string) (*CropBalance, error) {
@@ -243,64 +299,136 @@ func (s *SmartContract) GetCropHistory(ctx contractapi.TransactionContextInterfa
     return records, nil
 }
 
-func (s *SmartContract) PlantCrops(ctx contractapi.TransactionContextInterface, farmer string, amount float64) error {
+// PlantCrops moves amount out of the caller's balance into a new open planting and
+// returns the planting ID, which a later HarvestPlantedCrops must name.
+func (s *SmartContract) PlantCrops(ctx contractapi.TransactionContextInterface, amount Amount) (string, error) {
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return "", err
+    }
+    amount, err = requirePositive(amount, "planted amount")
+    if err != nil {
+        return "", err
+    }
+
     crops, err := s.GetCropBalance(ctx, farmer)
     if err != nil {
-        return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
+        return "", fmt.Errorf("failed to get crops for %s: %v", farmer, err)
     }
 
-    if crops.CropAmount < amount {
-        return fmt.Errorf("%s doesn't have enough crops to plant", farmer)
+    cmp, err := crops.compareAvailable(amount)
+    if err != nil {
+        return "", err
+    }
+    if cmp < 0 {
+        return "", fmt.Errorf("%s doesn't have enough crops to plant", farmer)
     }
 
-    crops.CropAmount -= amount
-    crops.Timestamp = time.Now().String()
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
 
-    cropJSON, err := json.Marshal(crops)
+    crops.CropAmount, err = crops.CropAmount.Sub(amount)
     if err != nil {
-        return err
+        return "", err
     }
+    crops.Timestamp = now.String()
 
-    err = ctx.GetStub().PutState(farmer, cropJSON)
+    err = putCropBalance(ctx, crops)
     if err != nil {
-        return err
+        return "", err
     }
 
-    plantingKey := fmt.Sprintf("Planting_%s_%s", farmer, time.Now().String())
-    plantingInfo := PlantingInfo{
+    planting := &PlantingInfo{
+        ID:            ctx.GetStub().GetTxID(),
         Farmer:        farmer,
         PlantedAmount: amount,
-        Yield:         0.0,
-        Timestamp:    time.Now().String(),
+        Yield:         zeroAmount,
+        Status:        plantingStatusOpen,
+        Timestamp:     now.String(),
     }
 
-    plantingJSON, err := json.Marshal(plantingInfo)
+    err = putPlanting(ctx, planting)
     if err != nil {
-        return err
+        return "", err
     }
 
-    return ctx.GetStub().PutState(plantingKey, plantingJSON)
+    return planting.ID, nil
 }
 
-func (s *SmartContract) HarvestPlantedCrops(ctx contractapi.TransactionContextInterface, farmer string, amount float64) error {
+// HarvestPlantedCrops records the yield of one of the caller's open plantings, closes it
+// and credits the yield to the caller's balance. A planting can only be harvested once.
+func (s *SmartContract) HarvestPlantedCrops(ctx contractapi.TransactionContextInterface, plantingID string, yield Amount) error {
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    yield, err = ParseAmount(string(yield))
+    if err != nil {
+        return fmt.Errorf("invalid yield: %v", err)
+    }
+
+    planting, err := s.GetPlanting(ctx, farmer, plantingID)
+    if err != nil {
+        return err
+    }
+    if planting.Status != plantingStatusOpen {
+        return fmt.Errorf("planting %s of %s was already harvested at %s", plantingID, farmer, planting.HarvestedAt)
+    }
+
     crops, err := s.GetCropBalance(ctx, farmer)
     if err != nil {
         return fmt.Errorf("failed to get crops for %s: %v", farmer, err)
     }
 
-    crops.CropAmount += amount
-    crops.Timestamp = time.Now().String()
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    planting.Yield = yield
+    planting.Status = plantingStatusClosed
+    planting.HarvestedAt = now.String()
 
-    cropJSON, err := json.Marshal(crops)
+    err = putPlanting(ctx, planting)
     if err != nil {
         return err
     }
 
-    return ctx.GetStub().PutState(farmer, cropJSON)
+    crops.CropAmount, err = crops.CropAmount.Add(yield)
+    if err != nil {
+        return err
+    }
+    crops.Timestamp = now.String()
+
+    return putCropBalance(ctx, crops)
+}
+
+func (s *SmartContract) GetPlanting(ctx contractapi.TransactionContextInterface, farmer string, plantingID string) (*PlantingInfo, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(plantingObjectType, []string{farmer, plantingID})
+    if err != nil {
+        return nil, err
+    }
+    plantingJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read planting from world state: %v", err)
+    }
+    if plantingJSON == nil {
+        return nil, fmt.Errorf("the planting %s of %s doesn't exist", plantingID, farmer)
+    }
+
+    var planting PlantingInfo
+    err = json.Unmarshal(plantingJSON, &planting)
+    if err != nil {
+        return nil, err
+    }
+
+    return &planting, nil
 }
 
 func (s *SmartContract) GetPlantingInfo(ctx contractapi.TransactionContextInterface, farmer string) ([]PlantingInfo, error) {
-    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("Planting", []string{farmer})
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(plantingObjectType, []string{farmer})
     if err != nil {
         return nil, err
     }
@@ -323,4 +451,4 @@ func (s *SmartContract) GetPlantingInfo(ctx contractapi.TransactionContextInterf
     }
 
     return plantings, nil
-}
\ No newline at end of file
+}
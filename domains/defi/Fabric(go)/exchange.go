@@ -0,0 +1,616 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "math"
+    "strconv"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    orderObjectType = "Order"
+
+    // orderBookObjectType indexes open orders by crop type, side, price and arrival
+    // sequence. Buy prices are stored inverted so that, on both sides, key order is
+    // priority order.
+    orderBookObjectType = "OrderBook"
+    tradeObjectType     = "Trade"
+
+    // orderSequenceObjectType holds the last sequence number given to an order resting
+    // on each side of a book, and tradeSequenceObjectType the last one given to a trade
+    // of each crop type. Time priority and trade history follow them rather than the
+    // client-chosen transaction timestamp.
+    orderSequenceObjectType = "OrderSequence"
+    tradeSequenceObjectType = "TradeSequence"
+
+    orderSideBuy  = "buy"
+    orderSideSell = "sell"
+
+    orderStatusOpen      = "Open"
+    orderStatusFilled    = "Filled"
+    orderStatusCancelled = "Cancelled"
+
+    // orderStatusClosed marks an order whose remainder still crossed the book when
+    // matching stopped, because the next fill was worth less than one quote unit or the
+    // order reached maxFillsPerOrder. Resting it would leave a crossed book, so the
+    // remainder is returned instead.
+    orderStatusClosed = "Closed"
+
+    // exchangeQuoteToken is the token orders are priced and settled in. It is minted and
+    // transferred like any crop token.
+    exchangeQuoteToken = "CASH"
+
+    // maxFillsPerOrder bounds the write set of a single PlaceOrder.
+    maxFillsPerOrder = 50
+)
+
+// Order is a limit order to buy or sell Quantity tokens of CropType at Price quote
+// tokens per unit or better. Placing an order escrows what it can spend: Quantity of the
+// crop token for a sell, and Quantity times Price of the quote token for a buy, which is
+// tracked in Reserved as fills at better prices hand back the difference. Sequence is
+// the order's place in time priority once it rests on the book.
+type Order struct {
+    ID        string    `json:"id"`
+    Owner     string    `json:"owner"`
+    CropType  string    `json:"cropType"`
+    Side      string    `json:"side"`
+    Price     Amount    `json:"price"`
+    Quantity  Amount    `json:"quantity"`
+    Remaining Amount    `json:"remaining"`
+    Reserved  Amount    `json:"reserved,omitempty"`
+    Sequence  uint64    `json:"sequence,omitempty"`
+    Status    string    `json:"status"`
+    Timestamp time.Time `json:"timestamp"`
+    ClosedAt  time.Time `json:"closedAt"`
+}
+
+// Trade is a single fill between a buy and a sell order, at the resting order's price.
+// Sequence orders the trades of a crop type.
+type Trade struct {
+    ID          string    `json:"id"`
+    Sequence    uint64    `json:"sequence"`
+    CropType    string    `json:"cropType"`
+    BuyOrderID  string    `json:"buyOrderId"`
+    SellOrderID string    `json:"sellOrderId"`
+    Buyer       string    `json:"buyer"`
+    Seller      string    `json:"seller"`
+    Price       Amount    `json:"price"`
+    Quantity    Amount    `json:"quantity"`
+    Value       Amount    `json:"value"`
+    Timestamp   time.Time `json:"timestamp"`
+}
+
+// OrderResult is returned by PlaceOrder and is the payload of the order events. Transfers
+// lists the token movements into and out of the escrow of every order involved.
+type OrderResult struct {
+    Order     *Order          `json:"order"`
+    Trades    []*Trade        `json:"trades"`
+    Transfers []TransferEvent `json:"transfers"`
+}
+
+// PlaceOrder escrows the caller's tokens, matches the order against the opposite side of
+// the book in price-time priority and rests any remainder on the book. Orders never match
+// against other orders of the same owner, and an order or remainder worth less than one
+// quote unit at its price is rejected or closed rather than traded for nothing. A
+// remainder that still crosses the book when matching stops, on a fill worth less than
+// one quote unit or after maxFillsPerOrder fills, is closed as well.
+func (s *SmartContract) PlaceOrder(ctx contractapi.TransactionContextInterface, cropType string, side string, quantity Amount, price Amount) (*OrderResult, error) {
+    if side != orderSideBuy && side != orderSideSell {
+        return nil, fmt.Errorf("order side must be %q or %q", orderSideBuy, orderSideSell)
+    }
+    if cropType == "" || cropType == exchangeQuoteToken {
+        return nil, fmt.Errorf("cannot trade %q against %s", cropType, exchangeQuoteToken)
+    }
+    quantity, err := requirePositive(quantity, "order quantity")
+    if err != nil {
+        return nil, err
+    }
+    price, err = requirePositive(price, "order price")
+    if err != nil {
+        return nil, err
+    }
+    value, err := orderValue(quantity, price)
+    if err != nil {
+        return nil, err
+    }
+    if value == zeroAmount {
+        return nil, fmt.Errorf("an order of %s at %s is worth less than one unit of %s", quantity, price, exchangeQuoteToken)
+    }
+
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    order := &Order{
+        ID:        ctx.GetStub().GetTxID(),
+        Owner:     owner,
+        CropType:  cropType,
+        Side:      side,
+        Price:     price,
+        Quantity:  quantity,
+        Remaining: quantity,
+        Status:    orderStatusOpen,
+        Timestamp: now,
+    }
+    changes := newBalanceChanges()
+    if side == orderSideBuy {
+        order.Reserved = value
+        err = changes.escrow(exchangeQuoteToken, owner, orderEscrow(order), value)
+    } else {
+        err = changes.escrow(cropType, owner, orderEscrow(order), quantity)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    trades, crossed, err := s.matchOrder(ctx, order, changes, now)
+    if err != nil {
+        return nil, err
+    }
+    dust, err := isDust(order)
+    if err != nil {
+        return nil, err
+    }
+    if dust {
+        if err := closeOrder(order, orderStatusFilled, changes, now); err != nil {
+            return nil, err
+        }
+    } else if crossed {
+        if err := closeOrder(order, orderStatusClosed, changes, now); err != nil {
+            return nil, err
+        }
+    } else {
+        order.Sequence, err = nextOrderSequence(ctx, cropType, side)
+        if err != nil {
+            return nil, err
+        }
+        if err := putOrderBookEntry(ctx, order); err != nil {
+            return nil, err
+        }
+    }
+    if err := putOrder(ctx, order); err != nil {
+        return nil, err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return nil, err
+    }
+
+    result := &OrderResult{Order: order, Trades: trades, Transfers: changes.transfers}
+    if err := emitEvent(ctx, "OrderPlaced", result); err != nil {
+        return nil, err
+    }
+
+    return result, nil
+}
+
+// CancelOrder removes the caller's open order from the book and returns what it still
+// holds in escrow.
+func (s *SmartContract) CancelOrder(ctx contractapi.TransactionContextInterface, id string) error {
+    order, err := s.GetOrder(ctx, id)
+    if err != nil {
+        return err
+    }
+    if order.Status != orderStatusOpen {
+        return fmt.Errorf("order %s is not open", id)
+    }
+
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if owner != order.Owner {
+        return fmt.Errorf("only %s can cancel order %s", order.Owner, id)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    if err := deleteOrderBookEntry(ctx, order); err != nil {
+        return err
+    }
+    changes := newBalanceChanges()
+    if err := closeOrder(order, orderStatusCancelled, changes, now); err != nil {
+        return err
+    }
+    if err := putOrder(ctx, order); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "OrderCancelled", OrderResult{Order: order, Trades: []*Trade{}, Transfers: changes.transfers})
+}
+
+func (s *SmartContract) GetOrder(ctx contractapi.TransactionContextInterface, id string) (*Order, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(orderObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    orderJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read order from world state: %v", err)
+    }
+    if orderJSON == nil {
+        return nil, fmt.Errorf("order %s does not exist", id)
+    }
+
+    var order Order
+    err = json.Unmarshal(orderJSON, &order)
+    if err != nil {
+        return nil, err
+    }
+
+    return &order, nil
+}
+
+// GetOrderBook returns the open orders on one side of a crop type's book, best price
+// first and earliest placed first within a price.
+func (s *SmartContract) GetOrderBook(ctx contractapi.TransactionContextInterface, cropType string, side string) ([]*Order, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderBookObjectType, []string{cropType, side})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var orders []*Order
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        order, err := s.orderFromBookKey(ctx, queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+        orders = append(orders, order)
+    }
+
+    return orders, nil
+}
+
+// GetTradeHistory returns the trades of a crop type in the order they happened.
+func (s *SmartContract) GetTradeHistory(ctx contractapi.TransactionContextInterface, cropType string) ([]*Trade, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(tradeObjectType, []string{cropType})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var trades []*Trade
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var trade Trade
+        err = json.Unmarshal(queryResponse.Value, &trade)
+        if err != nil {
+            return nil, err
+        }
+        trades = append(trades, &trade)
+    }
+
+    return trades, nil
+}
+
+// matchOrder fills the incoming order against resting orders until it is filled or the
+// best resting price no longer crosses. It reports crossed if it stopped while the order
+// still crossed a resting order of another owner: when the next fill would be worth less
+// than one quote unit, or when maxFillsPerOrder is reached.
+func (s *SmartContract) matchOrder(ctx contractapi.TransactionContextInterface, order *Order, changes *balanceChanges, now time.Time) ([]*Trade, bool, error) {
+    opposite := orderSideSell
+    if order.Side == orderSideSell {
+        opposite = orderSideBuy
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orderBookObjectType, []string{order.CropType, opposite})
+    if err != nil {
+        return nil, false, err
+    }
+    defer resultsIterator.Close()
+
+    sequenceKey, err := ctx.GetStub().CreateCompositeKey(tradeSequenceObjectType, []string{order.CropType})
+    if err != nil {
+        return nil, false, err
+    }
+    sequence, err := readSequence(ctx, sequenceKey)
+    if err != nil {
+        return nil, false, err
+    }
+
+    trades := []*Trade{}
+    crossed := false
+    for order.Remaining != zeroAmount && resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, false, err
+        }
+        resting, err := s.orderFromBookKey(ctx, queryResponse.Key)
+        if err != nil {
+            return nil, false, err
+        }
+
+        cmp, err := order.Price.Cmp(resting.Price)
+        if err != nil {
+            return nil, false, err
+        }
+        if (order.Side == orderSideBuy && cmp < 0) || (order.Side == orderSideSell && cmp > 0) {
+            break
+        }
+        if resting.Owner == order.Owner {
+            continue
+        }
+        if len(trades) == maxFillsPerOrder {
+            crossed = true
+            break
+        }
+
+        quantity := order.Remaining
+        cmp, err = quantity.Cmp(resting.Remaining)
+        if err != nil {
+            return nil, false, err
+        }
+        if cmp > 0 {
+            quantity = resting.Remaining
+        }
+
+        // A fill worth less than one quote unit would hand over crops for nothing.
+        value, err := orderValue(quantity, resting.Price)
+        if err != nil {
+            return nil, false, err
+        }
+        if value == zeroAmount {
+            crossed = true
+            break
+        }
+
+        buy, sell := order, resting
+        if order.Side == orderSideSell {
+            buy, sell = resting, order
+        }
+        trade, err := fillOrders(buy, sell, quantity, resting.Price, value, changes)
+        if err != nil {
+            return nil, false, err
+        }
+        sequence++
+        trade.ID = fmt.Sprintf("%s-%03d", order.ID, len(trades))
+        trade.Sequence = sequence
+        trade.Timestamp = now
+        if err := putTrade(ctx, trade); err != nil {
+            return nil, false, err
+        }
+        trades = append(trades, trade)
+
+        dust, err := isDust(resting)
+        if err != nil {
+            return nil, false, err
+        }
+        if dust {
+            if err := deleteOrderBookEntry(ctx, resting); err != nil {
+                return nil, false, err
+            }
+            if err := closeOrder(resting, orderStatusFilled, changes, now); err != nil {
+                return nil, false, err
+            }
+        }
+        if err := putOrder(ctx, resting); err != nil {
+            return nil, false, err
+        }
+    }
+
+    if len(trades) > 0 {
+        if err := putSequence(ctx, sequenceKey, sequence); err != nil {
+            return nil, false, err
+        }
+    }
+    return trades, crossed, nil
+}
+
+// fillOrders moves quantity at price, worth value, between a buy and a sell order. The
+// buyer's reservation is released at the buy order's own price and any saving from a
+// lower trade price goes straight back to the buyer.
+func fillOrders(buy *Order, sell *Order, quantity Amount, price Amount, value Amount, changes *balanceChanges) (*Trade, error) {
+    released, err := orderValue(quantity, buy.Price)
+    if err != nil {
+        return nil, err
+    }
+    saving, err := released.Sub(value)
+    if err != nil {
+        return nil, err
+    }
+
+    buy.Reserved, err = buy.Reserved.Sub(released)
+    if err != nil {
+        return nil, err
+    }
+    buy.Remaining, err = buy.Remaining.Sub(quantity)
+    if err != nil {
+        return nil, err
+    }
+    sell.Remaining, err = sell.Remaining.Sub(quantity)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := changes.release(exchangeQuoteToken, orderEscrow(buy), sell.Owner, value); err != nil {
+        return nil, err
+    }
+    if err := changes.release(exchangeQuoteToken, orderEscrow(buy), buy.Owner, saving); err != nil {
+        return nil, err
+    }
+    if err := changes.release(buy.CropType, orderEscrow(sell), buy.Owner, quantity); err != nil {
+        return nil, err
+    }
+
+    return &Trade{
+        CropType:    buy.CropType,
+        BuyOrderID:  buy.ID,
+        SellOrderID: sell.ID,
+        Buyer:       buy.Owner,
+        Seller:      sell.Owner,
+        Price:       price,
+        Quantity:    quantity,
+        Value:       value,
+    }, nil
+}
+
+// closeOrder returns whatever an order still holds in escrow to its owner: the unspent
+// reservation of a buy order, of which rounding can leave a few units even once it is
+// filled, or the unsold crops of a sell order. Remaining keeps the unfilled quantity, so
+// an order closed as filled with a dust remainder still shows it.
+func closeOrder(order *Order, status string, changes *balanceChanges, now time.Time) error {
+    if order.Side == orderSideBuy {
+        if err := changes.release(exchangeQuoteToken, orderEscrow(order), order.Owner, order.Reserved); err != nil {
+            return err
+        }
+        order.Reserved = zeroAmount
+    } else if err := changes.release(order.CropType, orderEscrow(order), order.Owner, order.Remaining); err != nil {
+        return err
+    }
+    order.Status = status
+    order.ClosedAt = now
+    return nil
+}
+
+// isDust reports whether what remains of an order, if anything, is worth less than one
+// quote unit at its own price. Such a remainder could never be filled for value, so it
+// is closed instead of resting on the book.
+func isDust(order *Order) (bool, error) {
+    value, err := orderValue(order.Remaining, order.Price)
+    if err != nil {
+        return false, err
+    }
+    return value == zeroAmount, nil
+}
+
+// orderValue is quantity times price in quote tokens, truncated.
+func orderValue(quantity Amount, price Amount) (Amount, error) {
+    priceUnits, err := price.Units()
+    if err != nil {
+        return "", err
+    }
+    return quantity.MulDiv(priceUnits, amountUnit)
+}
+
+func orderEscrow(order *Order) string {
+    return escrowAccount(orderObjectType, order.ID)
+}
+
+// nextOrderSequence hands out the next place in time priority on one side of a book.
+func nextOrderSequence(ctx contractapi.TransactionContextInterface, cropType string, side string) (uint64, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(orderSequenceObjectType, []string{cropType, side})
+    if err != nil {
+        return 0, err
+    }
+    sequence, err := readSequence(ctx, key)
+    if err != nil {
+        return 0, err
+    }
+    sequence++
+    if err := putSequence(ctx, key, sequence); err != nil {
+        return 0, err
+    }
+    return sequence, nil
+}
+
+// readSequence returns the last sequence number stored under key, or zero. A transaction
+// does not read its own writes, so a caller handing out several numbers reads once and
+// writes the last one back with putSequence.
+func readSequence(ctx contractapi.TransactionContextInterface, key string) (uint64, error) {
+    sequenceBytes, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return 0, fmt.Errorf("failed to read sequence from world state: %v", err)
+    }
+    if sequenceBytes == nil {
+        return 0, nil
+    }
+    sequence, err := strconv.ParseUint(string(sequenceBytes), 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid sequence under %q: %v", key, err)
+    }
+    return sequence, nil
+}
+
+func putSequence(ctx contractapi.TransactionContextInterface, key string, sequence uint64) error {
+    return ctx.GetStub().PutState(key, []byte(strconv.FormatUint(sequence, 10)))
+}
+
+func (s *SmartContract) orderFromBookKey(ctx contractapi.TransactionContextInterface, key string) (*Order, error) {
+    _, attributes, err := ctx.GetStub().SplitCompositeKey(key)
+    if err != nil {
+        return nil, err
+    }
+    return s.GetOrder(ctx, attributes[len(attributes)-1])
+}
+
+func orderBookKey(ctx contractapi.TransactionContextInterface, order *Order) (string, error) {
+    priceUnits, err := order.Price.Units()
+    if err != nil {
+        return "", err
+    }
+    if order.Side == orderSideBuy {
+        priceUnits = math.MaxInt64 - priceUnits
+    }
+    return ctx.GetStub().CreateCompositeKey(orderBookObjectType, []string{
+        order.CropType,
+        order.Side,
+        fmt.Sprintf("%019d", priceUnits),
+        fmt.Sprintf("%020d", order.Sequence),
+        order.ID,
+    })
+}
+
+func putOrderBookEntry(ctx contractapi.TransactionContextInterface, order *Order) error {
+    key, err := orderBookKey(ctx, order)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+func deleteOrderBookEntry(ctx contractapi.TransactionContextInterface, order *Order) error {
+    key, err := orderBookKey(ctx, order)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func putOrder(ctx contractapi.TransactionContextInterface, order *Order) error {
+    key, err := ctx.GetStub().CreateCompositeKey(orderObjectType, []string{order.ID})
+    if err != nil {
+        return err
+    }
+    orderJSON, err := json.Marshal(order)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, orderJSON)
+}
+
+func putTrade(ctx contractapi.TransactionContextInterface, trade *Trade) error {
+    key, err := ctx.GetStub().CreateCompositeKey(tradeObjectType, []string{
+        trade.CropType,
+        fmt.Sprintf("%020d", trade.Sequence),
+        trade.ID,
+    })
+    if err != nil {
+        return err
+    }
+    tradeJSON, err := json.Marshal(trade)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, tradeJSON)
+}
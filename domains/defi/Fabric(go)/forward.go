@@ -0,0 +1,519 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    forwardObjectType = "Forward"
+
+    // forwardCommitmentObjectType indexes committed contracts by farmer, buyer and
+    // delivery date, so a delivery finds the earliest-due contracts first.
+    forwardCommitmentObjectType = "ForwardCommitment"
+
+    // forwardStatusObjectType and forwardPartyObjectType index contracts by status and by
+    // buyer and farmer, so the status and counterparty queries read only their matches.
+    forwardStatusObjectType = "ForwardStatus"
+    forwardPartyObjectType  = "ForwardParty"
+
+    forwardStatusOffered   = "Offered"
+    forwardStatusCommitted = "Committed"
+    forwardStatusDelivered = "Delivered"
+    forwardStatusExpired   = "Expired"
+    forwardStatusCancelled = "Cancelled"
+
+    // deliveryDateKeyLayout is fixed-width so that commitment keys sort by date.
+    deliveryDateKeyLayout = "2006-01-02T15:04:05Z"
+)
+
+// ForwardContract is a buyer's pre-purchase of part of a farmer's harvest. The buyer
+// escrows Price in PaymentToken tokens when making the offer and the farmer posts Penalty
+// in the same token as a bond when committing. Crops delivered to the buyer through
+// DistributeCrops before DeliveryDate count towards Quantity; once it is met the farmer
+// receives the escrow and the bond back. A contract that is not met by DeliveryDate is
+// settled pro rata on what was delivered and the buyer keeps the bond.
+type ForwardContract struct {
+    ID           string    `json:"id"`
+    Buyer        string    `json:"buyer"`
+    Farmer       string    `json:"farmer"`
+    Quantity     Amount    `json:"quantity"`
+    Delivered    Amount    `json:"delivered"`
+    PaymentToken string    `json:"paymentToken"`
+    Price        Amount    `json:"price"`
+    Penalty      Amount    `json:"penalty"`
+    DeliveryDate time.Time `json:"deliveryDate"`
+    Status       string    `json:"status"`
+    Timestamp    time.Time `json:"timestamp"`
+    CommittedAt  time.Time `json:"committedAt"`
+    ClosedAt     time.Time `json:"closedAt"`
+    FarmerPaid   Amount    `json:"farmerPaid,omitempty"`
+    BuyerRefund  Amount    `json:"buyerRefund,omitempty"`
+}
+
+// ForwardEvent is the payload of the events of a single contract: the contract as stored
+// and the token movements into and out of its escrow.
+type ForwardEvent struct {
+    *ForwardContract
+    Transfers []TransferEvent `json:"transfers,omitempty"`
+}
+
+// ForwardsDeliveredEvent lists the contracts a delivery fulfilled and their payouts.
+type ForwardsDeliveredEvent struct {
+    Forwards  []*ForwardContract `json:"forwards"`
+    Transfers []TransferEvent    `json:"transfers"`
+}
+
+// OfferForward escrows price from the caller's paymentToken balance and offers to buy
+// quantity crops from farmer for delivery by deliveryDate (RFC 3339). The returned ID is
+// the transaction ID.
+func (s *SmartContract) OfferForward(ctx contractapi.TransactionContextInterface, farmer string, quantity Amount, paymentToken string, price Amount, penalty Amount, deliveryDate string) (string, error) {
+    quantity, err := requirePositive(quantity, "forward quantity")
+    if err != nil {
+        return "", err
+    }
+    price, err = requirePositive(price, "forward price")
+    if err != nil {
+        return "", err
+    }
+    penalty, err = ParseAmount(string(penalty))
+    if err != nil {
+        return "", fmt.Errorf("invalid penalty: %v", err)
+    }
+    deadline, err := time.Parse(time.RFC3339, deliveryDate)
+    if err != nil {
+        return "", fmt.Errorf("invalid delivery date: %v", err)
+    }
+
+    buyer, err := callerAccount(ctx)
+    if err != nil {
+        return "", err
+    }
+    if buyer == farmer {
+        return "", fmt.Errorf("a farmer cannot buy their own harvest forward")
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+    if !deadline.After(now) {
+        return "", fmt.Errorf("delivery date %s is not in the future", deliveryDate)
+    }
+
+    forward := &ForwardContract{
+        ID:           ctx.GetStub().GetTxID(),
+        Buyer:        buyer,
+        Farmer:       farmer,
+        Quantity:     quantity,
+        Delivered:    zeroAmount,
+        PaymentToken: paymentToken,
+        Price:        price,
+        Penalty:      penalty,
+        DeliveryDate: deadline.UTC().Truncate(time.Second),
+        Status:       forwardStatusOffered,
+        Timestamp:    now,
+    }
+    changes := newBalanceChanges()
+    if err := changes.escrow(paymentToken, buyer, forwardEscrow(forward), price); err != nil {
+        return "", err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return "", err
+    }
+    if err := putForward(ctx, forward, ""); err != nil {
+        return "", err
+    }
+    if err := emitEvent(ctx, "ForwardOffered", ForwardEvent{ForwardContract: forward, Transfers: changes.transfers}); err != nil {
+        return "", err
+    }
+
+    return forward.ID, nil
+}
+
+// CommitForward is called by the farmer to accept an offer, posting the penalty bond.
+func (s *SmartContract) CommitForward(ctx contractapi.TransactionContextInterface, id string) error {
+    forward, err := s.GetForward(ctx, id)
+    if err != nil {
+        return err
+    }
+    if forward.Status != forwardStatusOffered {
+        return fmt.Errorf("forward contract %s is not in an offered state", id)
+    }
+
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if farmer != forward.Farmer {
+        return fmt.Errorf("only %s can commit to forward contract %s", forward.Farmer, id)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if !forward.DeliveryDate.After(now) {
+        return fmt.Errorf("forward contract %s is past its delivery date", id)
+    }
+
+    changes := newBalanceChanges()
+    if err := changes.escrow(forward.PaymentToken, farmer, forwardEscrow(forward), forward.Penalty); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    forward.Status = forwardStatusCommitted
+    forward.CommittedAt = now
+    if err := putForward(ctx, forward, forwardStatusOffered); err != nil {
+        return err
+    }
+    if err := putForwardCommitment(ctx, forward); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "ForwardCommitted", ForwardEvent{ForwardContract: forward, Transfers: changes.transfers})
+}
+
+// CancelForward lets the buyer withdraw an offer the farmer has not committed to and
+// refunds the escrow.
+func (s *SmartContract) CancelForward(ctx contractapi.TransactionContextInterface, id string) error {
+    forward, err := s.GetForward(ctx, id)
+    if err != nil {
+        return err
+    }
+    if forward.Status != forwardStatusOffered {
+        return fmt.Errorf("forward contract %s is not in an offered state", id)
+    }
+
+    buyer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if buyer != forward.Buyer {
+        return fmt.Errorf("only %s can cancel forward contract %s", forward.Buyer, id)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    changes := newBalanceChanges()
+    if err := changes.release(forward.PaymentToken, forwardEscrow(forward), buyer, forward.Price); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    forward.Status = forwardStatusCancelled
+    forward.ClosedAt = now
+    forward.BuyerRefund = forward.Price
+    if err := putForward(ctx, forward, forwardStatusOffered); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "ForwardCancelled", ForwardEvent{ForwardContract: forward, Transfers: changes.transfers})
+}
+
+// SettleExpiredForward can be called by anyone once the delivery date has passed on a
+// contract that was not fully delivered. The farmer is paid for the share of Quantity
+// delivered in time; the buyer gets the rest of the escrow and the farmer's bond.
+func (s *SmartContract) SettleExpiredForward(ctx contractapi.TransactionContextInterface, id string) error {
+    forward, err := s.GetForward(ctx, id)
+    if err != nil {
+        return err
+    }
+    if forward.Status != forwardStatusOffered && forward.Status != forwardStatusCommitted {
+        return fmt.Errorf("forward contract %s is already closed", id)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if now.Before(forward.DeliveryDate) {
+        return fmt.Errorf("forward contract %s is not due until %s", id, forward.DeliveryDate.Format(time.RFC3339))
+    }
+
+    farmerPaid := zeroAmount
+    refund := forward.Price
+    if forward.Status == forwardStatusCommitted {
+        delivered, quantity, err := bothUnits(forward.Delivered, forward.Quantity)
+        if err != nil {
+            return err
+        }
+        farmerPaid, err = forward.Price.MulDiv(delivered, quantity)
+        if err != nil {
+            return err
+        }
+        refund, err = forward.Price.Sub(farmerPaid)
+        if err != nil {
+            return err
+        }
+        refund, err = refund.Add(forward.Penalty)
+        if err != nil {
+            return err
+        }
+        if err := deleteForwardCommitment(ctx, forward); err != nil {
+            return err
+        }
+    }
+
+    changes := newBalanceChanges()
+    if err := changes.release(forward.PaymentToken, forwardEscrow(forward), forward.Farmer, farmerPaid); err != nil {
+        return err
+    }
+    if err := changes.release(forward.PaymentToken, forwardEscrow(forward), forward.Buyer, refund); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    previousStatus := forward.Status
+    forward.Status = forwardStatusExpired
+    forward.ClosedAt = now
+    forward.FarmerPaid = farmerPaid
+    forward.BuyerRefund = refund
+    if err := putForward(ctx, forward, previousStatus); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "ForwardExpired", ForwardEvent{ForwardContract: forward, Transfers: changes.transfers})
+}
+
+func (s *SmartContract) GetForward(ctx contractapi.TransactionContextInterface, id string) (*ForwardContract, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(forwardObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    forwardJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read forward contract from world state: %v", err)
+    }
+    if forwardJSON == nil {
+        return nil, fmt.Errorf("forward contract %s does not exist", id)
+    }
+
+    var forward ForwardContract
+    err = json.Unmarshal(forwardJSON, &forward)
+    if err != nil {
+        return nil, err
+    }
+
+    return &forward, nil
+}
+
+func (s *SmartContract) GetForwardsByStatus(ctx contractapi.TransactionContextInterface, status string) ([]*ForwardContract, error) {
+    return s.indexedForwards(ctx, forwardStatusObjectType, status)
+}
+
+// GetForwardsByCounterparty returns the contracts in which account is either the buyer
+// or the farmer.
+func (s *SmartContract) GetForwardsByCounterparty(ctx contractapi.TransactionContextInterface, account string) ([]*ForwardContract, error) {
+    return s.indexedForwards(ctx, forwardPartyObjectType, account)
+}
+
+// applyForwardDeliveries counts crops moved from a farmer to a buyer towards their
+// committed contracts, earliest delivery date first. Contracts that are met are closed
+// and their escrow and bond released to the farmer. Any amount beyond the open
+// commitments is an ordinary transfer.
+func (s *SmartContract) applyForwardDeliveries(ctx contractapi.TransactionContextInterface, farmer string, buyer string, amount Amount) error {
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(forwardCommitmentObjectType, []string{farmer, buyer})
+    if err != nil {
+        return err
+    }
+    defer resultsIterator.Close()
+
+    var fulfilled []*ForwardContract
+    changes := newBalanceChanges()
+    remaining := amount
+    for remaining != zeroAmount && resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+        _, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return err
+        }
+
+        forward, err := s.GetForward(ctx, attributes[3])
+        if err != nil {
+            return err
+        }
+        if !now.Before(forward.DeliveryDate) {
+            continue
+        }
+
+        outstanding, err := forward.Quantity.Sub(forward.Delivered)
+        if err != nil {
+            return err
+        }
+        applied := remaining
+        cmp, err := applied.Cmp(outstanding)
+        if err != nil {
+            return err
+        }
+        if cmp > 0 {
+            applied = outstanding
+        }
+        remaining, err = remaining.Sub(applied)
+        if err != nil {
+            return err
+        }
+        forward.Delivered, err = forward.Delivered.Add(applied)
+        if err != nil {
+            return err
+        }
+
+        if forward.Delivered == forward.Quantity {
+            payout, err := forward.Price.Add(forward.Penalty)
+            if err != nil {
+                return err
+            }
+            if err := changes.release(forward.PaymentToken, forwardEscrow(forward), farmer, payout); err != nil {
+                return err
+            }
+            if err := deleteForwardCommitment(ctx, forward); err != nil {
+                return err
+            }
+            forward.Status = forwardStatusDelivered
+            forward.ClosedAt = now
+            forward.FarmerPaid = forward.Price
+            fulfilled = append(fulfilled, forward)
+        }
+        if err := putForward(ctx, forward, forwardStatusCommitted); err != nil {
+            return err
+        }
+    }
+
+    if len(fulfilled) == 0 {
+        return nil
+    }
+
+    // Payouts are credited through one set of balance changes, since a transaction does
+    // not read its own writes and a second credit to the same balance would overwrite
+    // the first.
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "ForwardsDelivered", ForwardsDeliveredEvent{Forwards: fulfilled, Transfers: changes.transfers})
+}
+
+// indexedForwards returns the contracts listed under value in a status or party index.
+func (s *SmartContract) indexedForwards(ctx contractapi.TransactionContextInterface, objectType string, value string) ([]*ForwardContract, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(objectType, []string{value})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var forwards []*ForwardContract
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        _, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+
+        forward, err := s.GetForward(ctx, attributes[1])
+        if err != nil {
+            return nil, err
+        }
+        forwards = append(forwards, forward)
+    }
+
+    return forwards, nil
+}
+
+// putForward writes a contract and keeps its index entries current. previousStatus is
+// the status the contract was stored with, or empty for a new contract, which is also
+// when its party entries are written since the buyer and farmer never change.
+func putForward(ctx contractapi.TransactionContextInterface, forward *ForwardContract, previousStatus string) error {
+    key, err := ctx.GetStub().CreateCompositeKey(forwardObjectType, []string{forward.ID})
+    if err != nil {
+        return err
+    }
+    forwardJSON, err := json.Marshal(forward)
+    if err != nil {
+        return err
+    }
+    if err := ctx.GetStub().PutState(key, forwardJSON); err != nil {
+        return err
+    }
+
+    if previousStatus == forward.Status {
+        return nil
+    }
+    if previousStatus != "" {
+        if err := deleteForwardIndexEntry(ctx, forwardStatusObjectType, previousStatus, forward.ID); err != nil {
+            return err
+        }
+    } else {
+        for _, party := range []string{forward.Buyer, forward.Farmer} {
+            if err := putForwardIndexEntry(ctx, forwardPartyObjectType, party, forward.ID); err != nil {
+                return err
+            }
+        }
+    }
+    return putForwardIndexEntry(ctx, forwardStatusObjectType, forward.Status, forward.ID)
+}
+
+func putForwardIndexEntry(ctx contractapi.TransactionContextInterface, objectType string, value string, id string) error {
+    key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{value, id})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+func deleteForwardIndexEntry(ctx contractapi.TransactionContextInterface, objectType string, value string, id string) error {
+    key, err := ctx.GetStub().CreateCompositeKey(objectType, []string{value, id})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func forwardEscrow(forward *ForwardContract) string {
+    return escrowAccount(forwardObjectType, forward.ID)
+}
+
+func forwardCommitmentKey(ctx contractapi.TransactionContextInterface, forward *ForwardContract) (string, error) {
+    return ctx.GetStub().CreateCompositeKey(forwardCommitmentObjectType, []string{
+        forward.Farmer,
+        forward.Buyer,
+        forward.DeliveryDate.UTC().Format(deliveryDateKeyLayout),
+        forward.ID,
+    })
+}
+
+func putForwardCommitment(ctx contractapi.TransactionContextInterface, forward *ForwardContract) error {
+    key, err := forwardCommitmentKey(ctx, forward)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+func deleteForwardCommitment(ctx contractapi.TransactionContextInterface, forward *ForwardContract) error {
+    key, err := forwardCommitmentKey(ctx, forward)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
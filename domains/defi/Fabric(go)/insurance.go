@@ -0,0 +1,409 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    policyObjectType = "InsurancePolicy"
+
+    policyStatusOffered   = "Offered"
+    policyStatusActive    = "Active"
+    policyStatusPaidOut   = "PaidOut"
+    policyStatusExpired   = "Expired"
+    policyStatusWithdrawn = "Withdrawn"
+    policyStatusVoided    = "Voided"
+
+    // settlementDelay gives oracles time to submit observations for the end of the
+    // window before a policy can be settled on them.
+    settlementDelay = 24 * time.Hour
+
+    // voidAfter is how long after the window a policy without a single observation from
+    // its oracle is voided, returning the payout to the insurer and the premium to the
+    // farmer.
+    voidAfter = 7 * 24 * time.Hour
+
+    // monitoringChaincodeName is the name the monitoring chaincode is deployed under on
+    // the same channel. Policies are settled against its weather observations.
+    monitoringChaincodeName = "monitoring"
+)
+
+// InsurancePolicy pays Payout to the farmer if the cumulative rainfall that OracleID
+// reported to the monitoring chaincode for Field over [WindowStart, WindowEnd) is below
+// RainfallThresholdMM. The insurer escrows the payout when offering the policy and the
+// farmer escrows the premium when buying it, so settlement never depends on either side
+// being solvent or cooperative.
+type InsurancePolicy struct {
+    ID                  string    `json:"id"`
+    Insurer             string    `json:"insurer"`
+    Farmer              string    `json:"farmer"`
+    Field               string    `json:"field"`
+    OracleID            string    `json:"oracleId"`
+    WindowStart         time.Time `json:"windowStart"`
+    WindowEnd           time.Time `json:"windowEnd"`
+    RainfallThresholdMM float64   `json:"rainfallThresholdMm"`
+    PaymentToken        string    `json:"paymentToken"`
+    Premium             Amount    `json:"premium"`
+    Payout              Amount    `json:"payout"`
+    Status              string    `json:"status"`
+    Timestamp           time.Time `json:"timestamp"`
+    PurchasedAt         time.Time `json:"purchasedAt"`
+    SettledAt           time.Time `json:"settledAt"`
+    ObservedRainfallMM  float64   `json:"observedRainfallMm"`
+    Observations        int       `json:"observations"`
+}
+
+// PolicyEvent is the payload of every policy event: the policy as stored and the token
+// movements into and out of its escrow.
+type PolicyEvent struct {
+    *InsurancePolicy
+    Transfers []TransferEvent `json:"transfers,omitempty"`
+}
+
+// OfferPolicy escrows payout from the caller's paymentToken balance and offers farmer a
+// rainfall policy on field for premium, settled on the observations of oracleID only.
+// The window bounds are RFC 3339 timestamps.
+func (s *SmartContract) OfferPolicy(ctx contractapi.TransactionContextInterface, farmer string, field string, oracleID string, windowStart string, windowEnd string, rainfallThresholdMM float64, paymentToken string, premium Amount, payout Amount) (string, error) {
+    start, err := time.Parse(time.RFC3339, windowStart)
+    if err != nil {
+        return "", fmt.Errorf("invalid window start: %v", err)
+    }
+    end, err := time.Parse(time.RFC3339, windowEnd)
+    if err != nil {
+        return "", fmt.Errorf("invalid window end: %v", err)
+    }
+    if !end.After(start) {
+        return "", fmt.Errorf("window end must be after window start")
+    }
+    if rainfallThresholdMM <= 0 {
+        return "", fmt.Errorf("rainfall threshold must be greater than zero")
+    }
+    if field == "" || oracleID == "" {
+        return "", fmt.Errorf("field and oracle ID must not be empty")
+    }
+    premium, err = ParseAmount(string(premium))
+    if err != nil {
+        return "", fmt.Errorf("invalid premium: %v", err)
+    }
+    payout, err = requirePositive(payout, "payout")
+    if err != nil {
+        return "", err
+    }
+
+    insurer, err := callerAccount(ctx)
+    if err != nil {
+        return "", err
+    }
+    if insurer == farmer {
+        return "", fmt.Errorf("a farmer cannot insure themselves")
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+    if !start.After(now) {
+        return "", fmt.Errorf("the policy window must start in the future")
+    }
+
+    policy := &InsurancePolicy{
+        ID:                  ctx.GetStub().GetTxID(),
+        Insurer:             insurer,
+        Farmer:              farmer,
+        Field:               field,
+        OracleID:            oracleID,
+        WindowStart:         start.UTC().Truncate(time.Second),
+        WindowEnd:           end.UTC().Truncate(time.Second),
+        RainfallThresholdMM: rainfallThresholdMM,
+        PaymentToken:        paymentToken,
+        Premium:             premium,
+        Payout:              payout,
+        Status:              policyStatusOffered,
+        Timestamp:           now,
+    }
+    changes := newBalanceChanges()
+    if err := changes.escrow(paymentToken, insurer, policyEscrow(policy), payout); err != nil {
+        return "", err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return "", err
+    }
+    if err := putPolicy(ctx, policy, "PolicyOffered", changes.transfers); err != nil {
+        return "", err
+    }
+
+    return policy.ID, nil
+}
+
+// BuyPolicy is called by the farmer to escrow the premium and activate the policy. The
+// premium goes to the insurer at settlement, or back to the farmer if the policy is
+// voided. Policies can only be bought before their window starts.
+func (s *SmartContract) BuyPolicy(ctx contractapi.TransactionContextInterface, id string) error {
+    policy, err := s.GetPolicy(ctx, id)
+    if err != nil {
+        return err
+    }
+    if policy.Status != policyStatusOffered {
+        return fmt.Errorf("policy %s is not in an offered state", id)
+    }
+
+    farmer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if farmer != policy.Farmer {
+        return fmt.Errorf("policy %s was offered to %s", id, policy.Farmer)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if !policy.WindowStart.After(now) {
+        return fmt.Errorf("the window of policy %s has already started", id)
+    }
+
+    changes := newBalanceChanges()
+    if err := changes.escrow(policy.PaymentToken, farmer, policyEscrow(policy), policy.Premium); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    policy.Status = policyStatusActive
+    policy.PurchasedAt = now
+
+    return putPolicy(ctx, policy, "PolicyPurchased", changes.transfers)
+}
+
+// WithdrawPolicy lets the insurer take back an offer that has not been bought, releasing
+// the escrowed payout.
+func (s *SmartContract) WithdrawPolicy(ctx contractapi.TransactionContextInterface, id string) error {
+    policy, err := s.GetPolicy(ctx, id)
+    if err != nil {
+        return err
+    }
+    if policy.Status != policyStatusOffered {
+        return fmt.Errorf("policy %s is not in an offered state", id)
+    }
+
+    insurer, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if insurer != policy.Insurer {
+        return fmt.Errorf("only %s can withdraw policy %s", policy.Insurer, id)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    changes := newBalanceChanges()
+    if err := changes.release(policy.PaymentToken, policyEscrow(policy), insurer, policy.Payout); err != nil {
+        return err
+    }
+    if err := changes.apply(ctx); err != nil {
+        return err
+    }
+
+    policy.Status = policyStatusWithdrawn
+    policy.SettledAt = now
+
+    return putPolicy(ctx, policy, "PolicyWithdrawn", changes.transfers)
+}
+
+// SettlePolicy can be called by anyone from settlementDelay after the policy window has
+// closed. It sums the rainfall the policy's oracle recorded for the field over the
+// window, pays the payout to the farmer if the trigger is met or back to the insurer if
+// it is not, and pays the premium to the insurer. Missing data is not drought: a window
+// without any observation from the oracle cannot be settled, and once voidAfter has
+// passed the policy is voided and both escrows are returned.
+func (s *SmartContract) SettlePolicy(ctx contractapi.TransactionContextInterface, id string) (*InsurancePolicy, error) {
+    policy, err := s.GetPolicy(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if policy.Status != policyStatusActive {
+        return nil, fmt.Errorf("policy %s is not active", id)
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+    settleFrom := policy.WindowEnd.Add(settlementDelay)
+    if now.Before(settleFrom) {
+        return nil, fmt.Errorf("policy %s cannot be settled until %s", id, settleFrom.Format(time.RFC3339))
+    }
+
+    observations, err := weatherObservations(ctx, policy.Field, policy.WindowStart, policy.WindowEnd)
+    if err != nil {
+        return nil, err
+    }
+    // The monitoring chaincode keys observations by location, time and oracle, so the
+    // oracle's observations have distinct times and each is counted once.
+    var rainfall float64
+    counted := 0
+    for _, observation := range observations {
+        if observation.Observation.OracleID != policy.OracleID {
+            continue
+        }
+        rainfall += observation.Observation.RainfallMM
+        counted++
+    }
+
+    escrow := policyEscrow(policy)
+    changes := newBalanceChanges()
+    if counted == 0 {
+        voidFrom := policy.WindowEnd.Add(voidAfter)
+        if now.Before(voidFrom) {
+            return nil, fmt.Errorf("no observations from %s for %s in the window of policy %s; it is voided if none arrive by %s", policy.OracleID, policy.Field, id, voidFrom.Format(time.RFC3339))
+        }
+        if err := changes.release(policy.PaymentToken, escrow, policy.Insurer, policy.Payout); err != nil {
+            return nil, err
+        }
+        if err := changes.release(policy.PaymentToken, escrow, policy.Farmer, policy.Premium); err != nil {
+            return nil, err
+        }
+        policy.Status = policyStatusVoided
+    } else {
+        beneficiary := policy.Insurer
+        policy.Status = policyStatusExpired
+        if rainfall < policy.RainfallThresholdMM {
+            beneficiary = policy.Farmer
+            policy.Status = policyStatusPaidOut
+        }
+        if err := changes.release(policy.PaymentToken, escrow, beneficiary, policy.Payout); err != nil {
+            return nil, err
+        }
+        if err := changes.release(policy.PaymentToken, escrow, policy.Insurer, policy.Premium); err != nil {
+            return nil, err
+        }
+    }
+    if err := changes.apply(ctx); err != nil {
+        return nil, err
+    }
+
+    policy.SettledAt = now
+    policy.ObservedRainfallMM = rainfall
+    policy.Observations = counted
+    if err := putPolicy(ctx, policy, "PolicySettled", changes.transfers); err != nil {
+        return nil, err
+    }
+
+    return policy, nil
+}
+
+func (s *SmartContract) GetPolicy(ctx contractapi.TransactionContextInterface, id string) (*InsurancePolicy, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(policyObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    policyJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read policy from world state: %v", err)
+    }
+    if policyJSON == nil {
+        return nil, fmt.Errorf("policy %s does not exist", id)
+    }
+
+    var policy InsurancePolicy
+    err = json.Unmarshal(policyJSON, &policy)
+    if err != nil {
+        return nil, err
+    }
+
+    return &policy, nil
+}
+
+// GetPoliciesByAccount returns the policies in which account is the farmer or the insurer.
+func (s *SmartContract) GetPoliciesByAccount(ctx contractapi.TransactionContextInterface, account string) ([]*InsurancePolicy, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(policyObjectType, []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var policies []*InsurancePolicy
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var policy InsurancePolicy
+        err = json.Unmarshal(queryResponse.Value, &policy)
+        if err != nil {
+            return nil, err
+        }
+        if policy.Farmer == account || policy.Insurer == account {
+            policies = append(policies, &policy)
+        }
+    }
+
+    return policies, nil
+}
+
+// weatherObservation is the part of the monitoring chaincode's SignedWeatherObservation
+// that settlement reads.
+type weatherObservation struct {
+    Observation struct {
+        OracleID   string    `json:"oracleId"`
+        Location   string    `json:"location"`
+        ObservedAt time.Time `json:"observedAt"`
+        RainfallMM float64   `json:"rainfallMm"`
+    } `json:"observation"`
+    TxId string `json:"txId"`
+}
+
+// weatherObservations queries the monitoring chaincode's GetWeatherObservations on the
+// same channel. The observations it reads become part of the settling transaction's read
+// set, so a late submission for the window invalidates a concurrent settlement.
+func weatherObservations(ctx contractapi.TransactionContextInterface, location string, from time.Time, to time.Time) ([]weatherObservation, error) {
+    args := [][]byte{
+        []byte("GetWeatherObservations"),
+        []byte(location),
+        []byte(from.Format(time.RFC3339)),
+        []byte(to.Format(time.RFC3339)),
+    }
+    response := ctx.GetStub().InvokeChaincode(monitoringChaincodeName, args, "")
+    if response.Status != shim.OK {
+        return nil, fmt.Errorf("failed to query weather observations from %s: %s", monitoringChaincodeName, response.Message)
+    }
+    if len(response.Payload) == 0 {
+        return nil, nil
+    }
+
+    var observations []weatherObservation
+    err := json.Unmarshal(response.Payload, &observations)
+    if err != nil {
+        return nil, fmt.Errorf("invalid weather observations from %s: %v", monitoringChaincodeName, err)
+    }
+
+    return observations, nil
+}
+
+func policyEscrow(policy *InsurancePolicy) string {
+    return escrowAccount(policyObjectType, policy.ID)
+}
+
+func putPolicy(ctx contractapi.TransactionContextInterface, policy *InsurancePolicy, event string, transfers []TransferEvent) error {
+    policyJSON, err := json.Marshal(policy)
+    if err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(policyObjectType, []string{policy.ID})
+    if err != nil {
+        return err
+    }
+    err = ctx.GetStub().PutState(key, policyJSON)
+    if err != nil {
+        return err
+    }
+    return emitEvent(ctx, event, PolicyEvent{InsurancePolicy: policy, Transfers: transfers})
+}
@@ -0,0 +1,300 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    loanObjectType = "Loan"
+
+    loanStatusRequested  = "Requested"
+    loanStatusApproved   = "Approved"
+    loanStatusRejected   = "Rejected"
+    loanStatusRepaid     = "Repaid"
+    loanStatusLiquidated = "Liquidated"
+
+    basisPoints = 10000
+
+    // maxRateBasisPoints caps interest and reward rates so rate*seconds products stay
+    // well inside int64.
+    maxRateBasisPoints = 1000000
+)
+
+// Loan mirrors the DeFinance loan in defi.sol. Amounts are crop balances rather than
+// ether, and InterestRate is in basis points charged over the full Duration.
+type Loan struct {
+    ID                 string    `json:"id"`
+    Farmer             string    `json:"farmer"`
+    Lender             string    `json:"lender"`
+    Amount             Amount    `json:"amount"`
+    InterestRate       uint64    `json:"interestRate"`
+    Duration           int64     `json:"duration"`
+    Status             string    `json:"status"`
+    Timestamp          time.Time `json:"timestamp"`
+    ApprovedAt         time.Time `json:"approvedAt"`
+    ClosedAt           time.Time `json:"closedAt"`
+    ClosedBy           string    `json:"closedBy,omitempty"`
+    Repayment          Amount    `json:"repayment,omitempty"`
+    CollateralCropType string    `json:"collateralCropType,omitempty"`
+    Collateral         Amount    `json:"collateral,omitempty"`
+}
+
+// RequestLoan opens a loan request for a farmer. duration is in seconds and interestRate
+// in basis points, as in defi.sol. The returned ID is the transaction ID.
+func (s *SmartContract) RequestLoan(ctx contractapi.TransactionContextInterface, farmer string, amount Amount, interestRate uint64, duration int64) (string, error) {
+    amount, err := requirePositive(amount, "loan amount")
+    if err != nil {
+        return "", err
+    }
+    if interestRate == 0 || interestRate > maxRateBasisPoints {
+        return "", fmt.Errorf("interest rate must be between 1 and %d basis points", maxRateBasisPoints)
+    }
+    if duration <= 0 {
+        return "", fmt.Errorf("duration must be greater than zero")
+    }
+    if err := requireAccount(ctx, farmer); err != nil {
+        return "", err
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    loan := &Loan{
+        ID:           ctx.GetStub().GetTxID(),
+        Farmer:       farmer,
+        Amount:       amount,
+        InterestRate: interestRate,
+        Duration:     duration,
+        Status:       loanStatusRequested,
+        Timestamp:    now,
+    }
+    if err := putLoan(ctx, loan, "LoanRequested"); err != nil {
+        return "", err
+    }
+
+    return loan.ID, nil
+}
+
+// ApproveLoan funds a requested loan from the caller's balance, making the caller the
+// lender. collateralCropType is the crop type the lender accepts the locked collateral
+// as, and must match the one the farmer attached it as, or be empty for an
+// uncollateralised loan.
+func (s *SmartContract) ApproveLoan(ctx contractapi.TransactionContextInterface, id string, collateralCropType string) error {
+    loan, err := s.GetLoan(ctx, id)
+    if err != nil {
+        return err
+    }
+    if loan.Status != loanStatusRequested {
+        return fmt.Errorf("loan %s is not in a requested state", id)
+    }
+    if collateralCropType != loan.CollateralCropType {
+        if loan.Collateral == "" {
+            return fmt.Errorf("loan %s has no collateral attached", id)
+        }
+        return fmt.Errorf("loan %s is collateralised as %s, not %s", id, loan.CollateralCropType, collateralCropType)
+    }
+
+    lender, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    if lender == loan.Farmer {
+        return fmt.Errorf("a farmer cannot fund their own loan")
+    }
+
+    if loan.Collateral != "" {
+        ok, err := s.withinLoanToValue(ctx, loan, loan.Amount, maxLoanToValueBasisPoints)
+        if err != nil {
+            return err
+        }
+        if !ok {
+            return fmt.Errorf("collateral of loan %s no longer covers %d basis points loan-to-value at the current price", id, maxLoanToValueBasisPoints)
+        }
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+    if err := s.moveCrops(ctx, lender, loan.Farmer, loan.Amount, now); err != nil {
+        return err
+    }
+
+    loan.Status = loanStatusApproved
+    loan.Lender = lender
+    loan.ApprovedAt = now
+
+    return putLoan(ctx, loan, "LoanApproved")
+}
+
+// RejectLoan withdraws a loan request and releases its collateral. Only the farmer who
+// requested the loan can reject it; lenders simply leave a request unfunded.
+func (s *SmartContract) RejectLoan(ctx contractapi.TransactionContextInterface, id string) error {
+    loan, err := s.GetLoan(ctx, id)
+    if err != nil {
+        return err
+    }
+    if loan.Status != loanStatusRequested {
+        return fmt.Errorf("loan %s is not in a requested state", id)
+    }
+    if err := requireAccount(ctx, loan.Farmer); err != nil {
+        return err
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    if loan.Collateral != "" {
+        if err := s.unlockCrops(ctx, loan.Farmer, loan.Collateral, now); err != nil {
+            return err
+        }
+    }
+
+    loan.Status = loanStatusRejected
+    loan.ClosedAt = now
+    loan.ClosedBy = loan.Farmer
+
+    return putLoan(ctx, loan, "LoanRejected")
+}
+
+// RepayLoan moves principal plus interest from the farmer to the lender. Interest accrues
+// linearly from approval, so early repayment costs less than the full rate and late
+// repayment keeps accruing at the same rate.
+func (s *SmartContract) RepayLoan(ctx contractapi.TransactionContextInterface, id string) (Amount, error) {
+    loan, err := s.GetLoan(ctx, id)
+    if err != nil {
+        return "", err
+    }
+    if loan.Status != loanStatusApproved {
+        return "", fmt.Errorf("loan %s is not in an approved state", id)
+    }
+    if err := requireAccount(ctx, loan.Farmer); err != nil {
+        return "", err
+    }
+
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    interest, err := accruedInterest(loan, now)
+    if err != nil {
+        return "", err
+    }
+    repayment, err := loan.Amount.Add(interest)
+    if err != nil {
+        return "", err
+    }
+    // Release the collateral along with the debit so that it can go towards the repayment.
+    if err := s.unlockAndDebitCrops(ctx, loan.Farmer, loan.Collateral, repayment, now); err != nil {
+        return "", err
+    }
+    if err := s.creditCrops(ctx, loan.Lender, repayment, now); err != nil {
+        return "", err
+    }
+
+    loan.Status = loanStatusRepaid
+    loan.ClosedAt = now
+    loan.ClosedBy = loan.Farmer
+    loan.Repayment = repayment
+
+    if err := putLoan(ctx, loan, "LoanRepaid"); err != nil {
+        return "", err
+    }
+
+    return repayment, nil
+}
+
+func (s *SmartContract) GetLoan(ctx contractapi.TransactionContextInterface, id string) (*Loan, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(loanObjectType, []string{id})
+    if err != nil {
+        return nil, err
+    }
+    loanJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read loan from world state: %v", err)
+    }
+    if loanJSON == nil {
+        return nil, fmt.Errorf("loan %s does not exist", id)
+    }
+
+    var loan Loan
+    err = json.Unmarshal(loanJSON, &loan)
+    if err != nil {
+        return nil, err
+    }
+
+    return &loan, nil
+}
+
+func (s *SmartContract) GetLoansByFarmer(ctx contractapi.TransactionContextInterface, farmer string) ([]*Loan, error) {
+    return s.filterLoans(ctx, func(loan *Loan) bool { return loan.Farmer == farmer })
+}
+
+func (s *SmartContract) GetLoansByLender(ctx contractapi.TransactionContextInterface, lender string) ([]*Loan, error) {
+    return s.filterLoans(ctx, func(loan *Loan) bool { return loan.Lender == lender })
+}
+
+func (s *SmartContract) filterLoans(ctx contractapi.TransactionContextInterface, match func(*Loan) bool) ([]*Loan, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(loanObjectType, []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var loans []*Loan
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var loan Loan
+        err = json.Unmarshal(queryResponse.Value, &loan)
+        if err != nil {
+            return nil, err
+        }
+        if match(&loan) {
+            loans = append(loans, &loan)
+        }
+    }
+
+    return loans, nil
+}
+
+// accruedInterest charges the rate pro rata per whole second since approval.
+func accruedInterest(loan *Loan, now time.Time) (Amount, error) {
+    elapsed := int64(now.Sub(loan.ApprovedAt) / time.Second)
+    if elapsed < 0 {
+        elapsed = 0
+    }
+    interest, err := loan.Amount.MulDiv(int64(loan.InterestRate), basisPoints)
+    if err != nil {
+        return "", err
+    }
+    return interest.MulDiv(elapsed, loan.Duration)
+}
+
+func putLoan(ctx contractapi.TransactionContextInterface, loan *Loan, event string) error {
+    loanJSON, err := json.Marshal(loan)
+    if err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(loanObjectType, []string{loan.ID})
+    if err != nil {
+        return err
+    }
+    err = ctx.GetStub().PutState(key, loanJSON)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().SetEvent(event, loanJSON)
+}
@@ -0,0 +1,119 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    plantingObjectType = "Planting"
+
+    plantingStatusOpen   = "open"
+    plantingStatusClosed = "closed"
+)
+
+type PlantingYield struct {
+    PlantingID    string  `json:"plantingId"`
+    PlantedAmount Amount  `json:"plantedAmount"`
+    Yield         Amount  `json:"yield"`
+    Ratio         float64 `json:"ratio"`
+}
+
+// YieldSummary reports the realised yield of a farmer's closed plantings, both per
+// planting and overall. Open plantings have no yield yet and are left out.
+type YieldSummary struct {
+    Farmer       string          `json:"farmer"`
+    Plantings    []PlantingYield `json:"plantings"`
+    TotalPlanted Amount          `json:"totalPlanted"`
+    TotalYield   Amount          `json:"totalYield"`
+    Ratio        float64         `json:"ratio"`
+}
+
+func (s *SmartContract) GetOpenPlantings(ctx contractapi.TransactionContextInterface, farmer string) ([]PlantingInfo, error) {
+    return s.plantingsWithStatus(ctx, farmer, plantingStatusOpen)
+}
+
+func (s *SmartContract) GetClosedPlantings(ctx contractapi.TransactionContextInterface, farmer string) ([]PlantingInfo, error) {
+    return s.plantingsWithStatus(ctx, farmer, plantingStatusClosed)
+}
+
+func (s *SmartContract) GetYieldRatios(ctx contractapi.TransactionContextInterface, farmer string) (*YieldSummary, error) {
+    closed, err := s.GetClosedPlantings(ctx, farmer)
+    if err != nil {
+        return nil, err
+    }
+
+    summary := &YieldSummary{
+        Farmer:       farmer,
+        Plantings:    []PlantingYield{},
+        TotalPlanted: zeroAmount,
+        TotalYield:   zeroAmount,
+    }
+    for _, planting := range closed {
+        ratio, err := planting.Yield.Ratio(planting.PlantedAmount)
+        if err != nil {
+            return nil, err
+        }
+        summary.Plantings = append(summary.Plantings, PlantingYield{
+            PlantingID:    planting.ID,
+            PlantedAmount: planting.PlantedAmount,
+            Yield:         planting.Yield,
+            Ratio:         ratio,
+        })
+        summary.TotalPlanted, err = summary.TotalPlanted.Add(planting.PlantedAmount)
+        if err != nil {
+            return nil, err
+        }
+        summary.TotalYield, err = summary.TotalYield.Add(planting.Yield)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    summary.Ratio, err = summary.TotalYield.Ratio(summary.TotalPlanted)
+    if err != nil {
+        return nil, err
+    }
+
+    return summary, nil
+}
+
+func (s *SmartContract) plantingsWithStatus(ctx contractapi.TransactionContextInterface, farmer string, status string) ([]PlantingInfo, error) {
+    plantings, err := s.GetPlantingInfo(ctx, farmer)
+    if err != nil {
+        return nil, err
+    }
+
+    var matching []PlantingInfo
+    for _, planting := range plantings {
+        if planting.Status == status {
+            matching = append(matching, planting)
+        }
+    }
+
+    return matching, nil
+}
+
+func putPlanting(ctx contractapi.TransactionContextInterface, planting *PlantingInfo) error {
+    key, err := ctx.GetStub().CreateCompositeKey(plantingObjectType, []string{planting.Farmer, planting.ID})
+    if err != nil {
+        return err
+    }
+    plantingJSON, err := json.Marshal(planting)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, plantingJSON)
+}
+
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+    ts, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+    }
+    return ptypes.Timestamp(ts)
+}
@@ -0,0 +1,268 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// cropAmountKeyField shadows cropAmount in every stored CropBalance, as written by
+// putCropBalance, with the amount in units, zero-padded to the width of int64. CouchDB compares strings as text, so only the
+// padded form orders the same way as the amounts themselves.
+const cropAmountKeyField = "cropAmountKey"
+
+// amountComparisonOperators are the operators whose operands are amounts and are
+// rewritten to padded keys when applied to cropAmount.
+var amountComparisonOperators = map[string]bool{
+    "$eq":  true,
+    "$ne":  true,
+    "$gt":  true,
+    "$gte": true,
+    "$lt":  true,
+    "$lte": true,
+    "$in":  true,
+    "$nin": true,
+}
+
+func amountKey(amount Amount) (string, error) {
+    units, err := amount.Units()
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%019d", units), nil
+}
+
+// queryableBalanceFields lists the CropBalance fields a rich query selector may
+// reference. Keeping the list closed stops clients from matching on fields no index
+// covers or on other document types.
+var queryableBalanceFields = map[string]bool{
+    "farmer":     true,
+    "cropAmount": true,
+    "timestamp":  true,
+}
+
+var selectorCombinators = map[string]bool{
+    "$and": true,
+    "$or":  true,
+    "$nor": true,
+}
+
+var selectorOperators = map[string]bool{
+    "$eq":        true,
+    "$ne":        true,
+    "$gt":        true,
+    "$gte":       true,
+    "$lt":        true,
+    "$lte":       true,
+    "$in":        true,
+    "$nin":       true,
+    "$exists":    true,
+    "$type":      true,
+    "$size":      true,
+    "$mod":       true,
+    "$regex":     true,
+    "$all":       true,
+    "$elemMatch": true,
+    "$not":       true,
+}
+
+// QueryCropBalances runs a CouchDB Mango selector against the CropBalance documents, e.g.
+// {"cropAmount": {"$gte": "500"}}. Conditions on cropAmount are rewritten to the padded
+// cropAmountKey, so range operators compare amounts numerically; only comparison, $in,
+// $nin and $exists operators are accepted on it. Balances last written before the key
+// was introduced have no cropAmountKey and do not match until they are next updated.
+func (s *SmartContract) QueryCropBalances(ctx contractapi.TransactionContextInterface, selector string) ([]*CropBalance, error) {
+    var parsed map[string]interface{}
+    err := json.Unmarshal([]byte(selector), &parsed)
+    if err != nil {
+        return nil, fmt.Errorf("selector is not a JSON object: %v", err)
+    }
+    if err := validateSelector(parsed, queryableBalanceFields); err != nil {
+        return nil, err
+    }
+    if err := rewriteAmountConditions(parsed); err != nil {
+        return nil, err
+    }
+
+    query, err := json.Marshal(map[string]interface{}{"selector": parsed})
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetQueryResult(string(query))
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var records []*CropBalance
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        // Composite keys hold other document types that may share field names.
+        if strings.HasPrefix(queryResponse.Key, "\x00") {
+            continue
+        }
+
+        var record CropBalance
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, &record)
+    }
+
+    return records, nil
+}
+
+func validateSelector(selector map[string]interface{}, allowed map[string]bool) error {
+    for key, value := range selector {
+        switch {
+        case selectorCombinators[key]:
+            clauses, ok := value.([]interface{})
+            if !ok {
+                return fmt.Errorf("%s expects an array of selectors", key)
+            }
+            for _, clause := range clauses {
+                sub, ok := clause.(map[string]interface{})
+                if !ok {
+                    return fmt.Errorf("%s expects an array of selectors", key)
+                }
+                if err := validateSelector(sub, allowed); err != nil {
+                    return err
+                }
+            }
+        case key == "$not":
+            sub, ok := value.(map[string]interface{})
+            if !ok {
+                return fmt.Errorf("$not expects a selector")
+            }
+            if err := validateSelector(sub, allowed); err != nil {
+                return err
+            }
+        case strings.HasPrefix(key, "$"):
+            return fmt.Errorf("operator %s is not allowed at field level", key)
+        default:
+            if !allowed[key] {
+                return fmt.Errorf("field %q cannot be queried", key)
+            }
+            if err := validateCondition(key, value); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func validateCondition(field string, condition interface{}) error {
+    operators, ok := condition.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    for operator, operand := range operators {
+        if !selectorOperators[operator] {
+            return fmt.Errorf("operator %q is not allowed on field %q", operator, field)
+        }
+        if operator == "$not" {
+            if err := validateCondition(field, operand); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// rewriteAmountConditions replaces every cropAmount condition in a validated selector
+// with the equivalent condition on cropAmountKey.
+func rewriteAmountConditions(selector map[string]interface{}) error {
+    for key, value := range selector {
+        switch {
+        case selectorCombinators[key]:
+            for _, clause := range value.([]interface{}) {
+                if err := rewriteAmountConditions(clause.(map[string]interface{})); err != nil {
+                    return err
+                }
+            }
+        case key == "$not":
+            if err := rewriteAmountConditions(value.(map[string]interface{})); err != nil {
+                return err
+            }
+        case key == "cropAmount":
+            condition, err := amountCondition(value)
+            if err != nil {
+                return err
+            }
+            delete(selector, key)
+            selector[cropAmountKeyField] = condition
+        }
+    }
+    return nil
+}
+
+func amountCondition(condition interface{}) (interface{}, error) {
+    operators, ok := condition.(map[string]interface{})
+    if !ok {
+        return amountOperand(condition)
+    }
+
+    rewritten := make(map[string]interface{}, len(operators))
+    for operator, operand := range operators {
+        switch {
+        case operator == "$exists":
+            rewritten[operator] = operand
+        case operator == "$not":
+            sub, err := amountCondition(operand)
+            if err != nil {
+                return nil, err
+            }
+            rewritten[operator] = sub
+        case operator == "$in" || operator == "$nin":
+            values, ok := operand.([]interface{})
+            if !ok {
+                return nil, fmt.Errorf("%s on cropAmount expects an array of amounts", operator)
+            }
+            keys := make([]interface{}, len(values))
+            for i, value := range values {
+                key, err := amountOperand(value)
+                if err != nil {
+                    return nil, err
+                }
+                keys[i] = key
+            }
+            rewritten[operator] = keys
+        case amountComparisonOperators[operator]:
+            key, err := amountOperand(operand)
+            if err != nil {
+                return nil, err
+            }
+            rewritten[operator] = key
+        default:
+            return nil, fmt.Errorf("operator %q is not supported on cropAmount", operator)
+        }
+    }
+    return rewritten, nil
+}
+
+// amountOperand converts an amount given as a string or JSON number to its padded key.
+func amountOperand(operand interface{}) (string, error) {
+    var text string
+    switch value := operand.(type) {
+    case string:
+        text = value
+    case float64:
+        text = strconv.FormatFloat(value, 'f', -1, 64)
+    default:
+        return "", fmt.Errorf("cropAmount conditions expect amounts, got %v", operand)
+    }
+
+    amount, err := ParseAmount(text)
+    if err != nil {
+        return "", fmt.Errorf("invalid cropAmount operand: %v", err)
+    }
+    return amountKey(amount)
+}
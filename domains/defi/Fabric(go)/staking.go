@@ -0,0 +1,419 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    stakingPoolObjectType = "StakingPool"
+    stakeObjectType       = "Stake"
+
+    secondsPerYear = 365 * 24 * 60 * 60
+)
+
+// StakingPool follows the StakingPool in defi.move. RewardRate is an annual rate in basis
+// points, accrued per second on each stake. Rewards are paid out of RewardReserve, which
+// anyone can top up with FundPoolRewards, so staking never creates crops from nothing.
+type StakingPool struct {
+    ID            string    `json:"id"`
+    Owner         string    `json:"owner"`
+    TotalStaked   Amount    `json:"totalStaked"`
+    RewardRate    uint64    `json:"rewardRate"`
+    RewardReserve Amount    `json:"rewardReserve"`
+    CreatedAt     time.Time `json:"createdAt"`
+    UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type Stake struct {
+    PoolID            string    `json:"poolId"`
+    Owner             string    `json:"owner"`
+    StakedAmount      Amount    `json:"stakedAmount"`
+    RewardAccumulated Amount    `json:"rewardAccumulated"`
+    LastAccrual       time.Time `json:"lastAccrual"`
+}
+
+type StakeEvent struct {
+    PoolID string `json:"poolId"`
+    Sender string `json:"sender"`
+    Amount Amount `json:"amount"`
+}
+
+type WithdrawEvent struct {
+    PoolID          string `json:"poolId"`
+    Sender          string `json:"sender"`
+    WithdrawnAmount Amount `json:"withdrawnAmount"`
+    RewardAmount    Amount `json:"rewardAmount"`
+}
+
+func (s *SmartContract) InitializePool(ctx contractapi.TransactionContextInterface, rewardRate uint64) (string, error) {
+    if rewardRate > maxRateBasisPoints {
+        return "", fmt.Errorf("reward rate must not exceed %d basis points", maxRateBasisPoints)
+    }
+
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return "", err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    pool := &StakingPool{
+        ID:            ctx.GetStub().GetTxID(),
+        Owner:         owner,
+        TotalStaked:   zeroAmount,
+        RewardRate:    rewardRate,
+        RewardReserve: zeroAmount,
+        CreatedAt:     now,
+        UpdatedAt:     now,
+    }
+    if err := putStakingPool(ctx, pool); err != nil {
+        return "", err
+    }
+
+    return pool.ID, nil
+}
+
+func (s *SmartContract) FundPoolRewards(ctx contractapi.TransactionContextInterface, poolID string, amount Amount) error {
+    amount, err := requirePositive(amount, "funding amount")
+    if err != nil {
+        return err
+    }
+
+    pool, err := s.GetStakingPool(ctx, poolID)
+    if err != nil {
+        return err
+    }
+    funder, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    if err := s.debitCrops(ctx, funder, amount, now); err != nil {
+        return err
+    }
+    pool.RewardReserve, err = pool.RewardReserve.Add(amount)
+    if err != nil {
+        return err
+    }
+    advancePool(pool, now)
+
+    return putStakingPool(ctx, pool)
+}
+
+// StakeTokens moves crops from the caller's balance into the pool. Staking again adds to
+// the caller's existing stake after accruing the reward earned so far.
+func (s *SmartContract) StakeTokens(ctx contractapi.TransactionContextInterface, poolID string, amount Amount) error {
+    amount, err := requirePositive(amount, "stake amount")
+    if err != nil {
+        return err
+    }
+
+    pool, err := s.GetStakingPool(ctx, poolID)
+    if err != nil {
+        return err
+    }
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    stake, err := readStake(ctx, poolID, owner)
+    if err != nil {
+        return err
+    }
+    if stake == nil {
+        // A new stake never accrues from before the pool state it was read against, even
+        // if the transaction is dated earlier.
+        stake = &Stake{PoolID: poolID, Owner: owner, StakedAmount: zeroAmount, RewardAccumulated: zeroAmount, LastAccrual: latest(now, pool.UpdatedAt)}
+    }
+    if err := accrueReward(pool, stake, now); err != nil {
+        return err
+    }
+
+    if err := s.debitCrops(ctx, owner, amount, now); err != nil {
+        return err
+    }
+    stake.StakedAmount, err = stake.StakedAmount.Add(amount)
+    if err != nil {
+        return err
+    }
+    pool.TotalStaked, err = pool.TotalStaked.Add(amount)
+    if err != nil {
+        return err
+    }
+    advancePool(pool, now)
+
+    if err := putStake(ctx, stake); err != nil {
+        return err
+    }
+    if err := putStakingPool(ctx, pool); err != nil {
+        return err
+    }
+
+    eventJSON, err := json.Marshal(StakeEvent{PoolID: poolID, Sender: owner, Amount: amount})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().SetEvent("StakeEvent", eventJSON)
+}
+
+// Withdraw returns the caller's whole stake plus accrued reward. If the reserve cannot
+// cover the reward, what it can cover is paid and the rest stays claimable on the stake.
+func (s *SmartContract) Withdraw(ctx contractapi.TransactionContextInterface, poolID string) (*WithdrawEvent, error) {
+    pool, err := s.GetStakingPool(ctx, poolID)
+    if err != nil {
+        return nil, err
+    }
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    stake, err := readStake(ctx, poolID, owner)
+    if err != nil {
+        return nil, err
+    }
+    if stake == nil {
+        return nil, fmt.Errorf("%s has no stake in pool %s", owner, poolID)
+    }
+    if err := accrueReward(pool, stake, now); err != nil {
+        return nil, err
+    }
+
+    reward := stake.RewardAccumulated
+    cmp, err := reward.Cmp(pool.RewardReserve)
+    if err != nil {
+        return nil, err
+    }
+    if cmp > 0 {
+        reward = pool.RewardReserve
+    }
+    principal := stake.StakedAmount
+    withdrawn, err := principal.Add(reward)
+    if err != nil {
+        return nil, err
+    }
+    if withdrawn == zeroAmount {
+        return nil, fmt.Errorf("%s has nothing to withdraw from pool %s", owner, poolID)
+    }
+
+    if err := s.creditCrops(ctx, owner, withdrawn, now); err != nil {
+        return nil, err
+    }
+    pool.TotalStaked, err = pool.TotalStaked.Sub(principal)
+    if err != nil {
+        return nil, err
+    }
+    pool.RewardReserve, err = pool.RewardReserve.Sub(reward)
+    if err != nil {
+        return nil, err
+    }
+    advancePool(pool, now)
+    stake.StakedAmount = zeroAmount
+    stake.RewardAccumulated, err = stake.RewardAccumulated.Sub(reward)
+    if err != nil {
+        return nil, err
+    }
+
+    if stake.RewardAccumulated != zeroAmount {
+        err = putStake(ctx, stake)
+    } else {
+        err = deleteStake(ctx, stake)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := putStakingPool(ctx, pool); err != nil {
+        return nil, err
+    }
+
+    event := &WithdrawEvent{PoolID: poolID, Sender: owner, WithdrawnAmount: withdrawn, RewardAmount: reward}
+    eventJSON, err := json.Marshal(event)
+    if err != nil {
+        return nil, err
+    }
+    if err := ctx.GetStub().SetEvent("WithdrawEvent", eventJSON); err != nil {
+        return nil, err
+    }
+
+    return event, nil
+}
+
+// CalculateReward returns the reward a stake has earned up to the transaction timestamp,
+// whether or not the reserve can currently pay it.
+func (s *SmartContract) CalculateReward(ctx contractapi.TransactionContextInterface, poolID string, owner string) (Amount, error) {
+    pool, err := s.GetStakingPool(ctx, poolID)
+    if err != nil {
+        return "", err
+    }
+    stake, err := readStake(ctx, poolID, owner)
+    if err != nil {
+        return "", err
+    }
+    if stake == nil {
+        return "", fmt.Errorf("%s has no stake in pool %s", owner, poolID)
+    }
+    now, err := txTimestamp(ctx)
+    if err != nil {
+        return "", err
+    }
+
+    if err := accrueReward(pool, stake, now); err != nil {
+        return "", err
+    }
+    return stake.RewardAccumulated, nil
+}
+
+func (s *SmartContract) GetStakingPool(ctx contractapi.TransactionContextInterface, poolID string) (*StakingPool, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(stakingPoolObjectType, []string{poolID})
+    if err != nil {
+        return nil, err
+    }
+    poolJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read staking pool from world state: %v", err)
+    }
+    if poolJSON == nil {
+        return nil, fmt.Errorf("staking pool %s does not exist", poolID)
+    }
+
+    var pool StakingPool
+    err = json.Unmarshal(poolJSON, &pool)
+    if err != nil {
+        return nil, err
+    }
+
+    return &pool, nil
+}
+
+func (s *SmartContract) GetPoolStakes(ctx contractapi.TransactionContextInterface, poolID string) ([]*Stake, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(stakeObjectType, []string{poolID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var stakes []*Stake
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var stake Stake
+        err = json.Unmarshal(queryResponse.Value, &stake)
+        if err != nil {
+            return nil, err
+        }
+        stakes = append(stakes, &stake)
+    }
+
+    return stakes, nil
+}
+
+// accrueReward adds the reward earned since the stake's last accrual. Rewards are simple
+// interest on the staked amount, so accruing more often does not compound. Only whole
+// seconds are accrued; the remainder carries over to the next accrual. LastAccrual only
+// moves forward, so a transaction dated before it accrues nothing.
+func accrueReward(pool *StakingPool, stake *Stake, now time.Time) error {
+    elapsed := int64(now.Sub(stake.LastAccrual) / time.Second)
+    if elapsed <= 0 {
+        return nil
+    }
+
+    reward, err := stake.StakedAmount.MulDiv(int64(pool.RewardRate)*elapsed, basisPoints*secondsPerYear)
+    if err != nil {
+        return err
+    }
+    stake.RewardAccumulated, err = stake.RewardAccumulated.Add(reward)
+    if err != nil {
+        return err
+    }
+    stake.LastAccrual = stake.LastAccrual.Add(time.Duration(elapsed) * time.Second)
+    return nil
+}
+
+// advancePool records that the pool was written at now. UpdatedAt never moves back, so
+// it bounds the earliest time any later stake can accrue from.
+func advancePool(pool *StakingPool, now time.Time) {
+    pool.UpdatedAt = latest(pool.UpdatedAt, now)
+}
+
+func latest(a time.Time, b time.Time) time.Time {
+    if b.After(a) {
+        return b
+    }
+    return a
+}
+
+func readStake(ctx contractapi.TransactionContextInterface, poolID string, owner string) (*Stake, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(stakeObjectType, []string{poolID, owner})
+    if err != nil {
+        return nil, err
+    }
+    stakeJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read stake from world state: %v", err)
+    }
+    if stakeJSON == nil {
+        return nil, nil
+    }
+
+    var stake Stake
+    err = json.Unmarshal(stakeJSON, &stake)
+    if err != nil {
+        return nil, err
+    }
+
+    return &stake, nil
+}
+
+func putStake(ctx contractapi.TransactionContextInterface, stake *Stake) error {
+    key, err := ctx.GetStub().CreateCompositeKey(stakeObjectType, []string{stake.PoolID, stake.Owner})
+    if err != nil {
+        return err
+    }
+    stakeJSON, err := json.Marshal(stake)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, stakeJSON)
+}
+
+func deleteStake(ctx contractapi.TransactionContextInterface, stake *Stake) error {
+    key, err := ctx.GetStub().CreateCompositeKey(stakeObjectType, []string{stake.PoolID, stake.Owner})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func putStakingPool(ctx contractapi.TransactionContextInterface, pool *StakingPool) error {
+    key, err := ctx.GetStub().CreateCompositeKey(stakingPoolObjectType, []string{pool.ID})
+    if err != nil {
+        return err
+    }
+    poolJSON, err := json.Marshal(pool)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, poolJSON)
+}
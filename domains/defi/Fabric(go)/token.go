@@ -0,0 +1,457 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The token API gives each crop type an ERC-20-style fungible token. Token balances are
+// kept apart from CropBalance and are addressed by account, which is the caller's
+// account for every operation that spends.
+const (
+    tokenBalanceObjectType   = "TokenBalance"
+    tokenAllowanceObjectType = "TokenAllowance"
+    tokenSupplyObjectType    = "TokenSupply"
+    tokenMinterObjectType    = "TokenMinter"
+)
+
+// TransferEvent is emitted as "Transfer" for every token movement. Mints have an empty
+// From and burns an empty To, as with ERC-20. Orders and contracts that hold tokens in
+// escrow list their movements in their own events instead, naming the escrow with
+// escrowAccount.
+type TransferEvent struct {
+    CropType string `json:"cropType"`
+    From     string `json:"from"`
+    To       string `json:"to"`
+    Value    Amount `json:"value"`
+}
+
+type ApprovalEvent struct {
+    CropType string `json:"cropType"`
+    Owner    string `json:"owner"`
+    Spender  string `json:"spender"`
+    Value    Amount `json:"value"`
+}
+
+func (s *SmartContract) TotalSupply(ctx contractapi.TransactionContextInterface, cropType string) (Amount, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenSupplyObjectType, []string{cropType})
+    if err != nil {
+        return "", err
+    }
+    return readTokenAmount(ctx, key)
+}
+
+func (s *SmartContract) BalanceOf(ctx contractapi.TransactionContextInterface, cropType string, account string) (Amount, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenBalanceObjectType, []string{cropType, account})
+    if err != nil {
+        return "", err
+    }
+    return readTokenAmount(ctx, key)
+}
+
+func (s *SmartContract) Allowance(ctx contractapi.TransactionContextInterface, cropType string, owner string, spender string) (Amount, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenAllowanceObjectType, []string{cropType, owner, spender})
+    if err != nil {
+        return "", err
+    }
+    return readTokenAmount(ctx, key)
+}
+
+func (s *SmartContract) Transfer(ctx contractapi.TransactionContextInterface, cropType string, to string, value Amount) error {
+    from, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    value, err = requirePositive(value, "transfer value")
+    if err != nil {
+        return err
+    }
+
+    return s.transferTokens(ctx, cropType, from, to, value)
+}
+
+// Approve sets, rather than adds to, the amount spender may move out of the caller's
+// balance. Setting it to zero removes the allowance.
+func (s *SmartContract) Approve(ctx contractapi.TransactionContextInterface, cropType string, spender string, value Amount) error {
+    owner, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    value, err = ParseAmount(string(value))
+    if err != nil {
+        return fmt.Errorf("invalid allowance: %v", err)
+    }
+    if spender == owner {
+        return fmt.Errorf("an account cannot approve itself")
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(tokenAllowanceObjectType, []string{cropType, owner, spender})
+    if err != nil {
+        return err
+    }
+    if err := writeTokenAmount(ctx, key, value); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "Approval", ApprovalEvent{CropType: cropType, Owner: owner, Spender: spender, Value: value})
+}
+
+func (s *SmartContract) TransferFrom(ctx contractapi.TransactionContextInterface, cropType string, from string, to string, value Amount) error {
+    spender, err := callerAccount(ctx)
+    if err != nil {
+        return err
+    }
+    value, err = requirePositive(value, "transfer value")
+    if err != nil {
+        return err
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(tokenAllowanceObjectType, []string{cropType, from, spender})
+    if err != nil {
+        return err
+    }
+    allowance, err := readTokenAmount(ctx, key)
+    if err != nil {
+        return err
+    }
+    remaining, err := allowance.Sub(value)
+    if err != nil {
+        return fmt.Errorf("transfer of %s exceeds the %s allowance of %s from %s", value, allowance, spender, from)
+    }
+    if err := writeTokenAmount(ctx, key, remaining); err != nil {
+        return err
+    }
+
+    return s.transferTokens(ctx, cropType, from, to, value)
+}
+
+// Mint creates new tokens of a crop type, typically against crops brought into storage.
+// Only accounts an admin has registered as minters for the crop type may mint.
+func (s *SmartContract) Mint(ctx contractapi.TransactionContextInterface, cropType string, to string, value Amount) error {
+    minter, err := s.requireMinter(ctx, cropType)
+    if err != nil {
+        return err
+    }
+    value, err = requirePositive(value, "mint value")
+    if err != nil {
+        return err
+    }
+    if to == "" {
+        to = minter
+    }
+
+    if err := adjustTokenSupply(ctx, cropType, value, true); err != nil {
+        return err
+    }
+    if err := adjustTokenBalance(ctx, cropType, to, value, true); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "Transfer", TransferEvent{CropType: cropType, To: to, Value: value})
+}
+
+// Burn destroys tokens from the calling minter's own balance.
+func (s *SmartContract) Burn(ctx contractapi.TransactionContextInterface, cropType string, value Amount) error {
+    minter, err := s.requireMinter(ctx, cropType)
+    if err != nil {
+        return err
+    }
+    value, err = requirePositive(value, "burn value")
+    if err != nil {
+        return err
+    }
+
+    if err := adjustTokenBalance(ctx, cropType, minter, value, false); err != nil {
+        return err
+    }
+    if err := adjustTokenSupply(ctx, cropType, value, false); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "Transfer", TransferEvent{CropType: cropType, From: minter, Value: value})
+}
+
+func (s *SmartContract) AddMinter(ctx contractapi.TransactionContextInterface, cropType string, account string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(tokenMinterObjectType, []string{cropType, account})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+func (s *SmartContract) RemoveMinter(ctx contractapi.TransactionContextInterface, cropType string, account string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    key, err := ctx.GetStub().CreateCompositeKey(tokenMinterObjectType, []string{cropType, account})
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().DelState(key)
+}
+
+func (s *SmartContract) IsMinter(ctx contractapi.TransactionContextInterface, cropType string, account string) (bool, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenMinterObjectType, []string{cropType, account})
+    if err != nil {
+        return false, err
+    }
+    value, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return false, fmt.Errorf("failed to read minter from world state: %v", err)
+    }
+    return value != nil, nil
+}
+
+func (s *SmartContract) requireMinter(ctx contractapi.TransactionContextInterface, cropType string) (string, error) {
+    account, err := callerAccount(ctx)
+    if err != nil {
+        return "", err
+    }
+    minter, err := s.IsMinter(ctx, cropType, account)
+    if err != nil {
+        return "", err
+    }
+    if !minter {
+        return "", fmt.Errorf("%s is not a minter of %s tokens", account, cropType)
+    }
+    return account, nil
+}
+
+func (s *SmartContract) transferTokens(ctx contractapi.TransactionContextInterface, cropType string, from string, to string, value Amount) error {
+    if to == "" {
+        return fmt.Errorf("transfer recipient must not be empty")
+    }
+    // A transaction does not read its own writes, so a self-transfer must not debit and
+    // then credit the same key. It only has to be covered by the balance.
+    if from == to {
+        balance, err := s.BalanceOf(ctx, cropType, from)
+        if err != nil {
+            return err
+        }
+        if _, err := balance.Sub(value); err != nil {
+            return fmt.Errorf("%s holds %s %s tokens, not enough to move %s", from, balance, cropType, value)
+        }
+        return emitEvent(ctx, "Transfer", TransferEvent{CropType: cropType, From: from, To: to, Value: value})
+    }
+    if err := adjustTokenBalance(ctx, cropType, from, value, false); err != nil {
+        return err
+    }
+    if err := adjustTokenBalance(ctx, cropType, to, value, true); err != nil {
+        return err
+    }
+
+    return emitEvent(ctx, "Transfer", TransferEvent{CropType: cropType, From: from, To: to, Value: value})
+}
+
+func adjustTokenBalance(ctx contractapi.TransactionContextInterface, cropType string, account string, value Amount, credit bool) error {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenBalanceObjectType, []string{cropType, account})
+    if err != nil {
+        return err
+    }
+    balance, err := readTokenAmount(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    var updated Amount
+    if credit {
+        updated, err = balance.Add(value)
+    } else {
+        updated, err = balance.Sub(value)
+        if err != nil {
+            return fmt.Errorf("%s holds %s %s tokens, not enough to move %s", account, balance, cropType, value)
+        }
+    }
+    if err != nil {
+        return err
+    }
+
+    return writeTokenAmount(ctx, key, updated)
+}
+
+func adjustTokenSupply(ctx contractapi.TransactionContextInterface, cropType string, value Amount, increase bool) error {
+    key, err := ctx.GetStub().CreateCompositeKey(tokenSupplyObjectType, []string{cropType})
+    if err != nil {
+        return err
+    }
+    supply, err := readTokenAmount(ctx, key)
+    if err != nil {
+        return err
+    }
+
+    if increase {
+        supply, err = supply.Add(value)
+    } else {
+        supply, err = supply.Sub(value)
+    }
+    if err != nil {
+        return err
+    }
+
+    return writeTokenAmount(ctx, key, supply)
+}
+
+// readTokenAmount returns zero for keys that were never written or were cleared.
+func readTokenAmount(ctx contractapi.TransactionContextInterface, key string) (Amount, error) {
+    valueJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return "", fmt.Errorf("failed to read token state: %v", err)
+    }
+    if valueJSON == nil {
+        return zeroAmount, nil
+    }
+
+    var value Amount
+    err = json.Unmarshal(valueJSON, &value)
+    if err != nil {
+        return "", err
+    }
+
+    return value, nil
+}
+
+// writeTokenAmount deletes zero amounts so empty balances and allowances do not linger.
+func writeTokenAmount(ctx contractapi.TransactionContextInterface, key string, value Amount) error {
+    if value == zeroAmount {
+        return ctx.GetStub().DelState(key)
+    }
+    valueJSON, err := json.Marshal(value)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().PutState(key, valueJSON)
+}
+
+// escrowAccount names the escrow of an order or contract in TransferEvents. Escrowed
+// tokens are held by the record itself rather than by a balance under this name, and
+// account IDs cannot contain the separator.
+func escrowAccount(kind string, id string) string {
+    return kind + ":" + id
+}
+
+type tokenAccount struct {
+    token   string
+    account string
+}
+
+// balanceChanges collects the token movements of a transaction and writes each balance
+// once. A transaction does not read its own writes, so adjusting the same balance twice,
+// such as a maker with several filled orders, would keep only the last adjustment.
+// Movements made through escrow and release are also recorded as TransferEvents, which
+// the caller emits with its own event since a transaction carries only one.
+type balanceChanges struct {
+    credits   map[tokenAccount]Amount
+    debits    map[tokenAccount]Amount
+    transfers []TransferEvent
+}
+
+func newBalanceChanges() *balanceChanges {
+    return &balanceChanges{
+        credits: make(map[tokenAccount]Amount),
+        debits:  make(map[tokenAccount]Amount),
+    }
+}
+
+func (c *balanceChanges) credit(token string, account string, amount Amount) error {
+    key := tokenAccount{token, account}
+    total, err := c.credits[key].Add(amount)
+    if err != nil {
+        return err
+    }
+    c.credits[key] = total
+    return nil
+}
+
+func (c *balanceChanges) debit(token string, account string, amount Amount) error {
+    key := tokenAccount{token, account}
+    total, err := c.debits[key].Add(amount)
+    if err != nil {
+        return err
+    }
+    c.debits[key] = total
+    return nil
+}
+
+// escrow moves amount from account into the named escrow.
+func (c *balanceChanges) escrow(token string, account string, escrow string, amount Amount) error {
+    if amount == zeroAmount {
+        return nil
+    }
+    if err := c.debit(token, account, amount); err != nil {
+        return err
+    }
+    c.transfers = append(c.transfers, TransferEvent{CropType: token, From: account, To: escrow, Value: amount})
+    return nil
+}
+
+// release moves amount out of the named escrow to account.
+func (c *balanceChanges) release(token string, escrow string, account string, amount Amount) error {
+    if amount == zeroAmount {
+        return nil
+    }
+    if err := c.credit(token, account, amount); err != nil {
+        return err
+    }
+    c.transfers = append(c.transfers, TransferEvent{CropType: token, From: escrow, To: account, Value: amount})
+    return nil
+}
+
+// apply writes the net change of every touched balance, in a fixed order so that
+// failures are reported the same way on every peer.
+func (c *balanceChanges) apply(ctx contractapi.TransactionContextInterface) error {
+    var keys []tokenAccount
+    for key := range c.credits {
+        keys = append(keys, key)
+    }
+    for key := range c.debits {
+        if _, ok := c.credits[key]; !ok {
+            keys = append(keys, key)
+        }
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i].token != keys[j].token {
+            return keys[i].token < keys[j].token
+        }
+        return keys[i].account < keys[j].account
+    })
+
+    for _, key := range keys {
+        credit, debit := c.credits[key], c.debits[key]
+        cmp, err := credit.Cmp(debit)
+        if err != nil {
+            return err
+        }
+        if cmp == 0 {
+            continue
+        }
+
+        var net Amount
+        if cmp > 0 {
+            net, err = credit.Sub(debit)
+        } else {
+            net, err = debit.Sub(credit)
+        }
+        if err != nil {
+            return err
+        }
+        if err := adjustTokenBalance(ctx, key.token, key.account, net, cmp > 0); err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload interface{}) error {
+    payloadJSON, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    return ctx.GetStub().SetEvent(name, payloadJSON)
+}
@@ -0,0 +1,58 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// maxBulkLoadRecords bounds the size of a single BulkLoad transaction's write set.
+const maxBulkLoadRecords = 500
+
+// BulkLoad writes crop records exported by the snapshot tool, exactly as exported, into
+// an empty or partially loaded ledger. It is restricted to admins and refuses to
+// overwrite any record that already exists.
+func (s *SmartContract) BulkLoad(ctx contractapi.TransactionContextInterface, recordsJSON string) (int, error) {
+    if err := requireAdmin(ctx); err != nil {
+        return 0, err
+    }
+
+    var records []CropRecord
+    if err := json.Unmarshal([]byte(recordsJSON), &records); err != nil {
+        return 0, fmt.Errorf("invalid bulk load records: %v", err)
+    }
+    if len(records) == 0 || len(records) > maxBulkLoadRecords {
+        return 0, fmt.Errorf("bulk load takes 1 to %d records, got %d", maxBulkLoadRecords, len(records))
+    }
+
+    seen := make(map[string]bool, len(records))
+    for _, record := range records {
+        if record.ID == "" {
+            return 0, fmt.Errorf("bulk load record without an ID")
+        }
+        if seen[record.ID] {
+            return 0, fmt.Errorf("crop record %s appears more than once in the batch", record.ID)
+        }
+        seen[record.ID] = true
+
+        exists, err := s.CropRecordExists(ctx, record.ID)
+        if err != nil {
+            return 0, err
+        }
+        if exists {
+            return 0, fmt.Errorf("the crop record %s already exists", record.ID)
+        }
+
+        recordJSON, err := json.Marshal(record)
+        if err != nil {
+            return 0, err
+        }
+        err = ctx.GetStub().PutState(record.ID, recordJSON)
+        if err != nil {
+            return 0, fmt.Errorf("failed to put crop record %s to world state: %v", record.ID, err)
+        }
+    }
+
+    return len(records), nil
+}
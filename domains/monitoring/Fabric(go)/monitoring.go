@@ -15,10 +15,24 @@ type SmartContract struct {
 }
 
 type CropRecord struct {
-    ID        string  `json:"id"`        
-    CropType  string  `json:"cropType"` 
-    Yield     float64 `json:"yield"`    
-    Timestamp string  `json:"timestamp"` 
+    ID           string             `json:"id"`
+    CropType     string             `json:"cropType"`
+    Yield        float64            `json:"yield"`
+    Timestamp    string             `json:"timestamp"`
+    CurrentStage string             `json:"currentStage,omitempty"`
+    Stages       []GrowthStageEntry `json:"stages,omitempty"`
+    Version      uint64             `json:"version"`
+    Deleted      *Tombstone         `json:"deleted,omitempty"`
+}
+
+type VersionConflictError struct {
+    ID       string
+    Expected uint64
+    Actual   uint64
+}
+
+func (e *VersionConflictError) Error() string {
+    return fmt.Sprintf("version conflict on crop record %s: expected version %d but found %d", e.ID, e.Expected, e.Actual)
 }
 
 type CropHistoryQueryResult struct {
@@ -35,12 +49,14 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
             CropType:  "Wheat",
             Yield:     150.5,
             Timestamp: time.Now().String(),
+            Version:   1,
         },
         {
             ID:        "Crop2",
             CropType:  "Corn",
             Yield:     200.2,
             Timestamp: time.Now().String(),
+            Version:   1,
         },
     }
 
@@ -73,6 +89,7 @@ func (s *SmartContract) AddCropRecord(ctx contractapi.TransactionContextInterfac
         CropType:  cropType,
         Yield:     yield,
         Timestamp: time.Now().String(),
+        Version:   1,
     }
 
     recordJSON, err := json.Marshal(record)
@@ -83,20 +100,23 @@ func (s *SmartContract) AddCropRecord(ctx contractapi.TransactionContextInterfac
     return ctx.GetStub().PutState(id, recordJSON)
 }
 
-func (s *SmartContract) UpdateCropRecord(ctx contractapi.TransactionContextInterface, id string, cropType string, yield float64) error {
-    exists, err := s.CropRecordExists(ctx, id)
+func (s *SmartContract) UpdateCropRecord(ctx contractapi.TransactionContextInterface, id string, cropType string, yield float64, expectedVersion uint64) error {
+    existing, err := s.GetCropRecord(ctx, id)
     if err != nil {
         return err
     }
-    if !exists {
-        return fmt.Errorf("the crop record %s does not exist", id)
+    if existing.Version != expectedVersion {
+        return &VersionConflictError{ID: id, Expected: expectedVersion, Actual: existing.Version}
     }
 
     record := CropRecord{
-        ID:        id,
-        CropType:  cropType,
-        Yield:     yield,
-        Timestamp: time.Now().String(),
+        ID:           id,
+        CropType:     cropType,
+        Yield:        yield,
+        Timestamp:    time.Now().String(),
+        CurrentStage: existing.CurrentStage,
+        Stages:       existing.Stages,
+        Version:      existing.Version + 1,
     }
 
     recordJSON, err := json.Marshal(record)
@@ -108,6 +128,18 @@ func (s *SmartContract) UpdateCropRecord(ctx contractapi.TransactionContextInter
 }
 
 func (s *SmartContract) GetCropRecord(ctx contractapi.TransactionContextInterface, id string) (*CropRecord, error) {
+    record, err := s.readCropRecord(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if record.Deleted != nil {
+        return nil, fmt.Errorf("the crop record %s has been deleted", id)
+    }
+
+    return record, nil
+}
+
+func (s *SmartContract) readCropRecord(ctx contractapi.TransactionContextInterface, id string) (*CropRecord, error) {
     recordJSON, err := ctx.GetStub().GetState(id)
     if err != nil {
         return nil, fmt.Errorf("failed to read crop record from world state: %v", err)
@@ -126,6 +158,14 @@ func (s *SmartContract) GetCropRecord(ctx contractapi.TransactionContextInterfac
 }
 
 func (s *SmartContract) GetAllCropRecords(ctx contractapi.TransactionContextInterface) ([]*CropRecord, error) {
+    return s.getAllCropRecords(ctx, false)
+}
+
+func (s *SmartContract) GetAllCropRecordsIncludingDeleted(ctx contractapi.TransactionContextInterface) ([]*CropRecord, error) {
+    return s.getAllCropRecords(ctx, true)
+}
+
+func (s *SmartContract) getAllCropRecords(ctx contractapi.TransactionContextInterface, includeDeleted bool) ([]*CropRecord, error) {
     resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
     if err != nil {
         return nil, err
@@ -144,6 +184,9 @@ func (s *SmartContract) GetAllCropRecords(ctx contractapi.TransactionContextInte
         if err != nil {
             return nil, err
         }
+        if record.Deleted != nil && !includeDeleted {
+            continue
+        }
         records = append(records, &record)
     }
 
@@ -203,13 +246,24 @@ func (s *SmartContract) CropRecordExists(ctx contractapi.TransactionContextInter
     return record != nil, nil
 }
 
-func (s *SmartContract) DeleteCropRecord(ctx contractapi.TransactionContextInterface, id string) error {
-    exists, err := s.CropRecordExists(ctx, id)
+func (s *SmartContract) DeleteCropRecord(ctx contractapi.TransactionContextInterface, id string, reason string) error {
+    record, err := s.GetCropRecord(ctx, id)
+    if err != nil {
+        return err
+    }
+
+    tombstone, err := newTombstone(ctx, reason)
     if err != nil {
         return err
     }
-    if !exists {
-        return fmt.Errorf("the crop record %s does not exist", id)
+    record.Deleted = tombstone
+    record.Timestamp = tombstone.DeletedAt.String()
+    record.Version++
+
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return err
     }
-    return ctx.GetStub().DelState(id)
+
+    return ctx.GetStub().PutState(id, recordJSON)
 }
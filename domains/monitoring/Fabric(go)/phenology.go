@@ -0,0 +1,119 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    StageSowing    = "sowing"
+    StageEmergence = "emergence"
+    StageFlowering = "flowering"
+    StageMaturity  = "maturity"
+    StageHarvest   = "harvest"
+)
+
+// growthStages lists the phenological stages in the only order a crop may pass through them.
+var growthStages = []string{StageSowing, StageEmergence, StageFlowering, StageMaturity, StageHarvest}
+
+type GrowthStageEntry struct {
+    Stage       string    `json:"stage"`
+    ObservedAt  time.Time `json:"observedAt"`
+    Observer    string    `json:"observer"`
+    ObserverMSP string    `json:"observerMsp"`
+}
+
+func growthStageIndex(stage string) int {
+    for i, s := range growthStages {
+        if s == stage {
+            return i
+        }
+    }
+    return -1
+}
+
+func (s *SmartContract) RecordGrowthStage(ctx contractapi.TransactionContextInterface, id string, stage string) error {
+    next := growthStageIndex(stage)
+    if next < 0 {
+        return fmt.Errorf("unknown growth stage %q, expected one of %v", stage, growthStages)
+    }
+
+    record, err := s.GetCropRecord(ctx, id)
+    if err != nil {
+        return err
+    }
+
+    if record.CurrentStage != "" && next <= growthStageIndex(record.CurrentStage) {
+        return fmt.Errorf("the crop record %s is already at stage %s and cannot move to %s", id, record.CurrentStage, stage)
+    }
+
+    observerMSP, observer, err := callerIdentity(ctx)
+    if err != nil {
+        return err
+    }
+    observedAt, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    record.Stages = append(record.Stages, GrowthStageEntry{
+        Stage:       stage,
+        ObservedAt:  observedAt,
+        Observer:    observer,
+        ObserverMSP: observerMSP,
+    })
+    record.CurrentStage = stage
+    record.Timestamp = observedAt.String()
+    record.Version++
+
+    recordJSON, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(id, recordJSON)
+}
+
+func (s *SmartContract) GetCropRecordsByStage(ctx contractapi.TransactionContextInterface, stage string) ([]*CropRecord, error) {
+    if growthStageIndex(stage) < 0 {
+        return nil, fmt.Errorf("unknown growth stage %q, expected one of %v", stage, growthStages)
+    }
+
+    records, err := s.GetAllCropRecords(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var staged []*CropRecord
+    for _, record := range records {
+        if record.CurrentStage == stage {
+            staged = append(staged, record)
+        }
+    }
+
+    return staged, nil
+}
+
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+    ts, err := ctx.GetStub().GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, fmt.Errorf("failed to read transaction timestamp: %v", err)
+    }
+    return ptypes.Timestamp(ts)
+}
+
+func callerIdentity(ctx contractapi.TransactionContextInterface) (string, string, error) {
+    mspID, err := ctx.GetClientIdentity().GetMSPID()
+    if err != nil {
+        return "", "", fmt.Errorf("failed to read client MSP ID: %v", err)
+    }
+    clientID, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return "", "", fmt.Errorf("failed to read client identity: %v", err)
+    }
+    return mspID, clientID, nil
+}
@@ -0,0 +1,151 @@
+package chaincode
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// queryableCropRecordFields lists the CropRecord fields a rich query selector may
+// reference. Keeping the list closed stops clients from matching on fields no index
+// covers or on other document types.
+var queryableCropRecordFields = map[string]bool{
+    "id":           true,
+    "cropType":     true,
+    "yield":        true,
+    "timestamp":    true,
+    "currentStage": true,
+    "version":      true,
+}
+
+var selectorCombinators = map[string]bool{
+    "$and": true,
+    "$or":  true,
+    "$nor": true,
+}
+
+var selectorOperators = map[string]bool{
+    "$eq":        true,
+    "$ne":        true,
+    "$gt":        true,
+    "$gte":       true,
+    "$lt":        true,
+    "$lte":       true,
+    "$in":        true,
+    "$nin":       true,
+    "$exists":    true,
+    "$type":      true,
+    "$size":      true,
+    "$mod":       true,
+    "$regex":     true,
+    "$all":       true,
+    "$elemMatch": true,
+    "$not":       true,
+}
+
+// QueryCropRecords runs a CouchDB Mango selector against the CropRecord documents, e.g.
+// {"cropType": "Corn", "yield": {"$gt": 100}}. Deleted records are left out.
+func (s *SmartContract) QueryCropRecords(ctx contractapi.TransactionContextInterface, selector string) ([]*CropRecord, error) {
+    var parsed map[string]interface{}
+    err := json.Unmarshal([]byte(selector), &parsed)
+    if err != nil {
+        return nil, fmt.Errorf("selector is not a JSON object: %v", err)
+    }
+    if err := validateSelector(parsed, queryableCropRecordFields); err != nil {
+        return nil, err
+    }
+
+    query, err := json.Marshal(map[string]interface{}{"selector": parsed})
+    if err != nil {
+        return nil, err
+    }
+
+    resultsIterator, err := ctx.GetStub().GetQueryResult(string(query))
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var records []*CropRecord
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        // Composite keys hold other document types that may share field names.
+        if strings.HasPrefix(queryResponse.Key, "\x00") {
+            continue
+        }
+
+        var record CropRecord
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        if record.Deleted != nil {
+            continue
+        }
+        records = append(records, &record)
+    }
+
+    return records, nil
+}
+
+func validateSelector(selector map[string]interface{}, allowed map[string]bool) error {
+    for key, value := range selector {
+        switch {
+        case selectorCombinators[key]:
+            clauses, ok := value.([]interface{})
+            if !ok {
+                return fmt.Errorf("%s expects an array of selectors", key)
+            }
+            for _, clause := range clauses {
+                sub, ok := clause.(map[string]interface{})
+                if !ok {
+                    return fmt.Errorf("%s expects an array of selectors", key)
+                }
+                if err := validateSelector(sub, allowed); err != nil {
+                    return err
+                }
+            }
+        case key == "$not":
+            sub, ok := value.(map[string]interface{})
+            if !ok {
+                return fmt.Errorf("$not expects a selector")
+            }
+            if err := validateSelector(sub, allowed); err != nil {
+                return err
+            }
+        case strings.HasPrefix(key, "$"):
+            return fmt.Errorf("operator %s is not allowed at field level", key)
+        default:
+            if !allowed[key] {
+                return fmt.Errorf("field %q cannot be queried", key)
+            }
+            if err := validateCondition(key, value); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func validateCondition(field string, condition interface{}) error {
+    operators, ok := condition.(map[string]interface{})
+    if !ok {
+        return nil
+    }
+    for operator, operand := range operators {
+        if !selectorOperators[operator] {
+            return fmt.Errorf("operator %q is not allowed on field %q", operator, field)
+        }
+        if operator == "$not" {
+            if err := validateCondition(field, operand); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
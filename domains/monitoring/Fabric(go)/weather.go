@@ -0,0 +1,311 @@
+package chaincode
+
+import (
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+    weatherOracleObjectType      = "WeatherOracle"
+    weatherObservationObjectType = "WeatherObservation"
+
+    // adminAttribute is the enrollment attribute that marks an identity as allowed to
+    // perform privileged operations such as registering oracles.
+    adminAttribute = "agri.admin"
+
+    // observationKeyLayout keeps observation keys fixed-width so they sort chronologically.
+    observationKeyLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+type WeatherOracle struct {
+    ID           string    `json:"id"`
+    PublicKey    string    `json:"publicKey"`
+    Active       bool      `json:"active"`
+    RegisteredBy string    `json:"registeredBy"`
+    RegisteredAt time.Time `json:"registeredAt"`
+}
+
+type WeatherObservation struct {
+    OracleID     string    `json:"oracleId"`
+    Location     string    `json:"location"`
+    ObservedAt   time.Time `json:"observedAt"`
+    RainfallMM   float64   `json:"rainfallMm"`
+    TemperatureC float64   `json:"temperatureC"`
+    WindSpeedMS  float64   `json:"windSpeedMs"`
+}
+
+type SignedWeatherObservation struct {
+    Observation WeatherObservation `json:"observation"`
+    Payload     string             `json:"payload"`
+    Signature   string             `json:"signature"`
+    TxId        string             `json:"txId"`
+}
+
+func (s *SmartContract) RegisterWeatherOracle(ctx contractapi.TransactionContextInterface, oracleID string, publicKeyPEM string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+    if oracleID == "" {
+        return fmt.Errorf("oracle ID must not be empty")
+    }
+    if _, err := parseOraclePublicKey(publicKeyPEM); err != nil {
+        return err
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(weatherOracleObjectType, []string{oracleID})
+    if err != nil {
+        return err
+    }
+    existing, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return fmt.Errorf("failed to read weather oracle from world state: %v", err)
+    }
+    if existing != nil {
+        return fmt.Errorf("the weather oracle %s already exists", oracleID)
+    }
+
+    registeredBy, err := ctx.GetClientIdentity().GetID()
+    if err != nil {
+        return fmt.Errorf("failed to read client identity: %v", err)
+    }
+    registeredAt, err := txTimestamp(ctx)
+    if err != nil {
+        return err
+    }
+
+    oracle := WeatherOracle{
+        ID:           oracleID,
+        PublicKey:    publicKeyPEM,
+        Active:       true,
+        RegisteredBy: registeredBy,
+        RegisteredAt: registeredAt,
+    }
+
+    oracleJSON, err := json.Marshal(oracle)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, oracleJSON)
+}
+
+func (s *SmartContract) DeactivateWeatherOracle(ctx contractapi.TransactionContextInterface, oracleID string) error {
+    if err := requireAdmin(ctx); err != nil {
+        return err
+    }
+
+    oracle, err := s.GetWeatherOracle(ctx, oracleID)
+    if err != nil {
+        return err
+    }
+    oracle.Active = false
+
+    key, err := ctx.GetStub().CreateCompositeKey(weatherOracleObjectType, []string{oracleID})
+    if err != nil {
+        return err
+    }
+    oracleJSON, err := json.Marshal(oracle)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, oracleJSON)
+}
+
+func (s *SmartContract) GetWeatherOracle(ctx contractapi.TransactionContextInterface, oracleID string) (*WeatherOracle, error) {
+    key, err := ctx.GetStub().CreateCompositeKey(weatherOracleObjectType, []string{oracleID})
+    if err != nil {
+        return nil, err
+    }
+    oracleJSON, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read weather oracle from world state: %v", err)
+    }
+    if oracleJSON == nil {
+        return nil, fmt.Errorf("the weather oracle %s does not exist", oracleID)
+    }
+
+    var oracle WeatherOracle
+    err = json.Unmarshal(oracleJSON, &oracle)
+    if err != nil {
+        return nil, err
+    }
+
+    return &oracle, nil
+}
+
+// SubmitWeatherObservation records an observation whose JSON payload was signed by the
+// oracle's private key. The signature is checked against the exact payload bytes, so the
+// oracle does not need to agree with the chaincode on a canonical encoding.
+func (s *SmartContract) SubmitWeatherObservation(ctx contractapi.TransactionContextInterface, oracleID string, payload string, signature string) error {
+    oracle, err := s.GetWeatherOracle(ctx, oracleID)
+    if err != nil {
+        return err
+    }
+    if !oracle.Active {
+        return fmt.Errorf("the weather oracle %s is not active", oracleID)
+    }
+
+    sig, err := base64.StdEncoding.DecodeString(signature)
+    if err != nil {
+        return fmt.Errorf("signature is not valid base64: %v", err)
+    }
+    if err := verifyOracleSignature(oracle.PublicKey, []byte(payload), sig); err != nil {
+        return fmt.Errorf("observation from oracle %s rejected: %v", oracleID, err)
+    }
+
+    var observation WeatherObservation
+    err = json.Unmarshal([]byte(payload), &observation)
+    if err != nil {
+        return fmt.Errorf("failed to parse observation payload: %v", err)
+    }
+    if observation.OracleID != oracleID {
+        return fmt.Errorf("observation was issued for oracle %s, not %s", observation.OracleID, oracleID)
+    }
+    if observation.Location == "" {
+        return fmt.Errorf("observation location must not be empty")
+    }
+    if observation.ObservedAt.IsZero() {
+        return fmt.Errorf("observation time must be set")
+    }
+    if observation.RainfallMM < 0 || observation.WindSpeedMS < 0 {
+        return fmt.Errorf("rainfall and wind speed must not be negative")
+    }
+
+    key, err := ctx.GetStub().CreateCompositeKey(weatherObservationObjectType, []string{
+        observation.Location,
+        observation.ObservedAt.UTC().Format(observationKeyLayout),
+        oracleID,
+    })
+    if err != nil {
+        return err
+    }
+    existing, err := ctx.GetStub().GetState(key)
+    if err != nil {
+        return fmt.Errorf("failed to read weather observation from world state: %v", err)
+    }
+    if existing != nil {
+        return fmt.Errorf("oracle %s already reported %s at %s", oracleID, observation.Location, observation.ObservedAt)
+    }
+
+    signed := SignedWeatherObservation{
+        Observation: observation,
+        Payload:     payload,
+        Signature:   signature,
+        TxId:        ctx.GetStub().GetTxID(),
+    }
+
+    signedJSON, err := json.Marshal(signed)
+    if err != nil {
+        return err
+    }
+
+    return ctx.GetStub().PutState(key, signedJSON)
+}
+
+// GetWeatherObservations returns the observations for a location within [from, to),
+// both given as RFC 3339 timestamps. An empty bound leaves that side of the window open.
+func (s *SmartContract) GetWeatherObservations(ctx contractapi.TransactionContextInterface, location string, from string, to string) ([]*SignedWeatherObservation, error) {
+    var start, end time.Time
+    var err error
+    if from != "" {
+        start, err = time.Parse(time.RFC3339, from)
+        if err != nil {
+            return nil, fmt.Errorf("invalid window start %q: %v", from, err)
+        }
+    }
+    if to != "" {
+        end, err = time.Parse(time.RFC3339, to)
+        if err != nil {
+            return nil, fmt.Errorf("invalid window end %q: %v", to, err)
+        }
+    }
+
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(weatherObservationObjectType, []string{location})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    var observations []*SignedWeatherObservation
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+
+        var signed SignedWeatherObservation
+        err = json.Unmarshal(queryResponse.Value, &signed)
+        if err != nil {
+            return nil, err
+        }
+
+        observedAt := signed.Observation.ObservedAt
+        if !start.IsZero() && observedAt.Before(start) {
+            continue
+        }
+        if !end.IsZero() && !observedAt.Before(end) {
+            continue
+        }
+        observations = append(observations, &signed)
+    }
+
+    return observations, nil
+}
+
+func parseOraclePublicKey(publicKeyPEM string) (interface{}, error) {
+    block, _ := pem.Decode([]byte(publicKeyPEM))
+    if block == nil {
+        return nil, fmt.Errorf("oracle public key is not PEM encoded")
+    }
+
+    key, err := x509.ParsePKIXPublicKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse oracle public key: %v", err)
+    }
+
+    switch key.(type) {
+    case *ecdsa.PublicKey, ed25519.PublicKey:
+        return key, nil
+    default:
+        return nil, fmt.Errorf("unsupported oracle key type %T, expected ECDSA or Ed25519", key)
+    }
+}
+
+func verifyOracleSignature(publicKeyPEM string, payload []byte, signature []byte) error {
+    key, err := parseOraclePublicKey(publicKeyPEM)
+    if err != nil {
+        return err
+    }
+
+    switch k := key.(type) {
+    case *ecdsa.PublicKey:
+        digest := sha256.Sum256(payload)
+        if !ecdsa.VerifyASN1(k, digest[:], signature) {
+            return fmt.Errorf("ECDSA signature verification failed")
+        }
+    case ed25519.PublicKey:
+        if !ed25519.Verify(k, payload, signature) {
+            return fmt.Errorf("Ed25519 signature verification failed")
+        }
+    }
+
+    return nil
+}
+
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+    err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true")
+    if err != nil {
+        return fmt.Errorf("the caller is not authorised for this operation: %v", err)
+    }
+    return nil
+}
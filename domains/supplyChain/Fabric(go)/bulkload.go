@@ -0,0 +1,72 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// adminAttribute is the enrollment attribute that marks an identity as allowed to
+	// perform privileged operations such as bulk loads.
+	adminAttribute = "agri.admin"
+
+	// maxBulkLoadRecords bounds the size of a single BulkLoad transaction's write set.
+	maxBulkLoadRecords = 500
+)
+
+// BulkLoad writes crops exported by the snapshot tool, exactly as exported, into
+// an empty or partially loaded ledger. It is restricted to admins and refuses to
+// overwrite any record that already exists.
+func (s *SmartContract) BulkLoad(ctx contractapi.TransactionContextInterface, recordsJSON string) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	var records []Crop
+	if err := json.Unmarshal([]byte(recordsJSON), &records); err != nil {
+		return 0, fmt.Errorf("invalid bulk load records: %v", err)
+	}
+	if len(records) == 0 || len(records) > maxBulkLoadRecords {
+		return 0, fmt.Errorf("bulk load takes 1 to %d records, got %d", maxBulkLoadRecords, len(records))
+	}
+
+	seen := make(map[string]bool, len(records))
+	for _, record := range records {
+		if record.CropID == "" {
+			return 0, fmt.Errorf("bulk load crop without a crop ID")
+		}
+		if seen[record.CropID] {
+			return 0, fmt.Errorf("crop %s appears more than once in the batch", record.CropID)
+		}
+		seen[record.CropID] = true
+
+		exists, err := s.CropExists(ctx, record.CropID)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			return 0, fmt.Errorf("the crop %s already exists", record.CropID)
+		}
+
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return 0, err
+		}
+		err = ctx.GetStub().PutState(record.CropID, recordJSON)
+		if err != nil {
+			return 0, fmt.Errorf("failed to put crop %s to world state: %v", record.CropID, err)
+		}
+	}
+
+	return len(records), nil
+}
+
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true")
+	if err != nil {
+		return fmt.Errorf("the caller is not authorised for this operation: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	hashSHA2256 = 0x12
+	hashSHA2512 = 0x13
+
+	// maxDagPBBlockSize is the default IPFS chunk size. Files up to this size are imported
+	// as a single dag-pb node, which is the only dag-pb layout rebuilt here.
+	maxDagPBBlockSize = 256 * 1024
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// verifyCIDBytes checks that data hashes to the digest in cid. Raw-codec CIDs hash the
+// file bytes directly. dag-pb CIDs, including every CIDv0, hash a UnixFS node instead,
+// which is rebuilt here for files that IPFS imports as a single block with its default
+// settings; larger files need the importer's original chunking and are not supported.
+// The chaincode has already validated every CID it stores, so this only decodes as much
+// as verification needs.
+func verifyCIDBytes(cid string, data []byte) error {
+	if len(cid) < 2 {
+		return fmt.Errorf("invalid CID %q: too short", cid)
+	}
+
+	var codec uint64 = codecDagPB
+	var multihash []byte
+	if len(cid) == 46 && strings.HasPrefix(cid, "Qm") {
+		decoded, err := decodeBase58(cid)
+		if err != nil {
+			return fmt.Errorf("invalid CID %q: %v", cid, err)
+		}
+		multihash = decoded
+	} else {
+		raw, err := decodeMultibase(cid)
+		if err != nil {
+			return fmt.Errorf("invalid CID %q: %v", cid, err)
+		}
+		version, n := binary.Uvarint(raw)
+		if n <= 0 || version != 1 {
+			return fmt.Errorf("invalid CID %q: unsupported version", cid)
+		}
+		raw = raw[n:]
+		codec, n = binary.Uvarint(raw)
+		if n <= 0 {
+			return fmt.Errorf("invalid CID %q: malformed codec", cid)
+		}
+		multihash = raw[n:]
+	}
+
+	hashCode, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return fmt.Errorf("invalid CID %q: malformed multihash", cid)
+	}
+	multihash = multihash[n:]
+	length, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return fmt.Errorf("invalid CID %q: malformed multihash", cid)
+	}
+	raw := multihash[n:]
+	if uint64(len(raw)) != length {
+		return fmt.Errorf("invalid CID %q: digest is %d bytes, multihash declares %d", cid, len(raw), length)
+	}
+
+	var block []byte
+	switch codec {
+	case codecRaw:
+		block = data
+	case codecDagPB:
+		if len(data) > maxDagPBBlockSize {
+			return fmt.Errorf("CID %s is a dag-pb CID and the file is larger than one %d byte block; only single-block files can be verified", cid, maxDagPBBlockSize)
+		}
+		block = unixFSFileNode(data)
+	default:
+		return fmt.Errorf("CID %s uses codec 0x%x; only raw and dag-pb CIDs can be verified against file bytes", cid, codec)
+	}
+
+	var digest []byte
+	switch hashCode {
+	case hashSHA2256:
+		sum := sha256.Sum256(block)
+		digest = sum[:]
+	case hashSHA2512:
+		sum := sha512.Sum512(block)
+		digest = sum[:]
+	default:
+		return fmt.Errorf("verification of multihash 0x%x digests is not supported", hashCode)
+	}
+
+	if !bytes.Equal(digest, raw) {
+		return fmt.Errorf("data hashes to %s, CID %s expects %s", hex.EncodeToString(digest), cid, hex.EncodeToString(raw))
+	}
+	return nil
+}
+
+// unixFSFileNode encodes data the way the IPFS importer stores a file that fits in one
+// block: a dag-pb node without links whose Data field is a UnixFS File message carrying
+// the bytes and the file size. Fields are written in ascending order, as protobuf does.
+func unixFSFileNode(data []byte) []byte {
+	var unixfs []byte
+	unixfs = appendProtoVarint(unixfs, 1, 2) // Type: File
+	if len(data) > 0 {
+		unixfs = appendProtoBytes(unixfs, 2, data)
+	}
+	unixfs = appendProtoVarint(unixfs, 3, uint64(len(data)))
+
+	return appendProtoBytes(nil, 1, unixfs)
+}
+
+func appendProtoVarint(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendProtoBytes(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// decodeMultibase and decodeBase58 are copies of the decoders in the dataStorage
+// chaincode's cid.go, which cannot be imported from here. Keep the two in step.
+func decodeMultibase(text string) ([]byte, error) {
+	prefix, body := text[0], text[1:]
+	switch prefix {
+	case 'b':
+		return base32Lower.DecodeString(body)
+	case 'B':
+		return base32Lower.DecodeString(strings.ToLower(body))
+	case 'z':
+		return decodeBase58(body)
+	case 'f', 'F':
+		return hex.DecodeString(body)
+	default:
+		return nil, fmt.Errorf("unsupported multibase prefix %q", prefix)
+	}
+}
+
+func decodeBase58(text string) ([]byte, error) {
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range text {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	decoded := value.Bytes()
+	leadingZeros := 0
+	for leadingZeros < len(text) && text[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+	return append(make([]byte, leadingZeros), decoded...), nil
+}
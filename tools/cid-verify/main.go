@@ -0,0 +1,97 @@
+// Command cid-verify checks a local copy of a document against a content identifier,
+// either one given on the command line or every document attached to a dataStorage
+// crop record. It exits non-zero if the file matches none of them. Raw CIDs can be
+// checked for any file; dag-pb CIDs, including CIDv0, only for files of up to 256 KiB
+// that IPFS stores as a single block.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"agri-blockchain-benchmark/tools/internal/gateway"
+)
+
+// documentRef mirrors the fields of the chaincode's DocumentRef that verification needs.
+type documentRef struct {
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+}
+
+func main() {
+	var (
+		cfg           gateway.Config
+		cid           = flag.String("cid", "", "CID to check the file against")
+		recordID      = flag.String("record", "", "crop record whose attached documents the file is checked against")
+		channelName   = flag.String("channel", "mychannel", "channel name")
+		chaincodeName = flag.String("chaincode", "dataStorage", "dataStorage chaincode name")
+	)
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s (-cid CID | -record ID [gateway flags]) FILE\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Raw CIDs can be checked for any file. dag-pb CIDs, including CIDv0, can only be\nchecked for files of up to %d bytes, which IPFS stores as a single block.\n", maxDagPBBlockSize)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (*cid == "") == (*recordID == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *cid != "" {
+		if err := verifyCIDBytes(*cid, data); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s matches %s\n", flag.Arg(0), *cid)
+		return
+	}
+
+	documents, err := readDocuments(cfg, *channelName, *chaincodeName, *recordID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, document := range documents {
+		if document.Size != int64(len(data)) {
+			continue
+		}
+		if err := verifyCIDBytes(document.CID, data); err != nil {
+			log.Printf("%s has the size of %s but does not match it: %v", flag.Arg(0), document.CID, err)
+			continue
+		}
+		fmt.Printf("%s matches %s on crop record %s\n", flag.Arg(0), document.CID, *recordID)
+		return
+	}
+	log.Fatalf("%s matches none of the %d documents attached to crop record %s", flag.Arg(0), len(documents), *recordID)
+}
+
+func readDocuments(cfg gateway.Config, channelName string, chaincodeName string, recordID string) ([]documentRef, error) {
+	gw, conn, err := gateway.Connect(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+	defer conn.Close()
+	defer gw.Close()
+
+	contract := gw.GetNetwork(channelName).GetContract(chaincodeName)
+	result, err := contract.EvaluateTransaction("GetDocuments", recordID)
+	if err != nil {
+		return nil, fmt.Errorf("GetDocuments: %w", err)
+	}
+
+	var documents []documentRef
+	if len(result) == 0 {
+		return documents, nil
+	}
+	if err := json.Unmarshal(result, &documents); err != nil {
+		return nil, fmt.Errorf("failed to decode documents of crop record %s: %w", recordID, err)
+	}
+	return documents, nil
+}
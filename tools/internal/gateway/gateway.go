@@ -0,0 +1,101 @@
+// Package gateway connects the benchmark tools to a Fabric peer through the Fabric
+// Gateway, with the connection settings every tool takes on its command line.
+package gateway
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type Config struct {
+	PeerEndpoint string
+	TLSCertPath  string
+	HostOverride string
+	MSPID        string
+	CertPath     string
+	KeyPath      string
+}
+
+// RegisterFlags adds the connection flags to fs, storing their values in c.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.PeerEndpoint, "peer", "localhost:7051", "gateway peer endpoint")
+	fs.StringVar(&c.TLSCertPath, "tls-cert", "", "peer TLS CA certificate")
+	fs.StringVar(&c.HostOverride, "host-override", "", "TLS server name override")
+	fs.StringVar(&c.MSPID, "msp-id", "Org1MSP", "client MSP ID")
+	fs.StringVar(&c.CertPath, "cert", "", "client certificate")
+	fs.StringVar(&c.KeyPath, "key", "", "client private key")
+}
+
+func Connect(cfg Config) (*client.Gateway, *grpc.ClientConn, error) {
+	tlsPEM, err := os.ReadFile(cfg.TLSCertPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	tlsCert, err := identity.CertificateFromPEM(tlsPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsCert)
+
+	conn, err := grpc.NewClient(cfg.PeerEndpoint, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, cfg.HostOverride)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create gRPC connection: %w", err)
+	}
+
+	certPEM, err := os.ReadFile(cfg.CertPath)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	cert, err := identity.CertificateFromPEM(certPEM)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	id, err := identity.NewX509Identity(cfg.MSPID, cert)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	keyPEM, err := os.ReadFile(cfg.KeyPath)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read client private key: %w", err)
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	gw, err := client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(conn),
+		client.WithEvaluateTimeout(5*time.Second),
+		client.WithEndorseTimeout(15*time.Second),
+		client.WithSubmitTimeout(5*time.Second),
+		client.WithCommitStatusTimeout(1*time.Minute),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return gw, conn, nil
+}
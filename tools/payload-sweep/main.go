@@ -0,0 +1,246 @@
+// Command payload-sweep measures the dataStorage chunked payload path across a range
+// of payload sizes. For every size it creates a crop record, uploads a random payload
+// in chunks, finalizes it and reads it back, then prints one CSV row of timings.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"agri-blockchain-benchmark/tools/internal/gateway"
+)
+
+func main() {
+	var (
+		cfg           gateway.Config
+		sizesFlag     = flag.String("sizes", "64KiB,256KiB,1MiB,4MiB,16MiB", "comma-separated payload sizes")
+		chunkSizeFlag = flag.String("chunk-size", "256KiB", "chunk size, at most the chaincode's 256KiB limit")
+		repetitions   = flag.Int("repetitions", 3, "uploads per payload size")
+		concurrency   = flag.Int("concurrency", 4, "chunk transactions submitted in parallel")
+		prefix        = flag.String("prefix", fmt.Sprintf("sweep-%d", time.Now().Unix()), "crop record ID prefix")
+		channelName   = flag.String("channel", "mychannel", "channel name")
+		chaincodeName = flag.String("chaincode", "dataStorage", "dataStorage chaincode name")
+	)
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	sizes, err := parseSizes(*sizesFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chunkSize, err := parseSize(*chunkSizeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	gw, conn, err := gateway.Connect(cfg)
+	if err != nil {
+		log.Fatalf("failed to connect to gateway: %v", err)
+	}
+	defer conn.Close()
+	defer gw.Close()
+	contract := gw.GetNetwork(*channelName).GetContract(*chaincodeName)
+
+	out := csv.NewWriter(os.Stdout)
+	defer out.Flush()
+	out.Write([]string{"size_bytes", "chunks", "repetition", "transactions", "upload_ms", "finalize_ms", "read_ms", "total_ms", "tps", "avg_latency_ms"})
+
+	for _, size := range sizes {
+		for rep := 1; rep <= *repetitions; rep++ {
+			recordID := fmt.Sprintf("%s-%d-%d", *prefix, size, rep)
+			result, err := runUpload(contract, recordID, size, chunkSize, *concurrency)
+			if err != nil {
+				log.Fatalf("size %d repetition %d: %v", size, rep, err)
+			}
+			out.Write(result.row(size, rep))
+			out.Flush()
+		}
+	}
+}
+
+type uploadResult struct {
+	chunks       int
+	transactions int
+	upload       time.Duration
+	finalize     time.Duration
+	read         time.Duration
+	latencyTotal time.Duration
+}
+
+// row reports TPS over the submitted chunk and finalize transactions only; the read-back
+// uses evaluations and is reported separately.
+func (r uploadResult) row(size int, rep int) []string {
+	submitted := r.upload + r.finalize
+	total := submitted + r.read
+	return []string{
+		strconv.Itoa(size),
+		strconv.Itoa(r.chunks),
+		strconv.Itoa(rep),
+		strconv.Itoa(r.transactions),
+		strconv.FormatInt(r.upload.Milliseconds(), 10),
+		strconv.FormatInt(r.finalize.Milliseconds(), 10),
+		strconv.FormatInt(r.read.Milliseconds(), 10),
+		strconv.FormatInt(total.Milliseconds(), 10),
+		strconv.FormatFloat(float64(r.transactions)/submitted.Seconds(), 'f', 2, 64),
+		strconv.FormatFloat(float64(r.latencyTotal.Milliseconds())/float64(r.transactions), 'f', 2, 64),
+	}
+}
+
+func runUpload(contract *client.Contract, recordID string, size int, chunkSize int, concurrency int) (uploadResult, error) {
+	var result uploadResult
+
+	payload := make([]byte, size)
+	if _, err := rand.Read(payload); err != nil {
+		return result, err
+	}
+	digest := sha256.Sum256(payload)
+
+	var chunks [][]byte
+	for start := 0; start < len(payload); start += chunkSize {
+		end := start + chunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunks = append(chunks, payload[start:end])
+	}
+	result.chunks = len(chunks)
+
+	// Record creation and opening the upload are setup, not part of the measured path.
+	if _, err := contract.SubmitTransaction("PlantCrop", recordID, "payload-sweep", "", "0"); err != nil {
+		return result, fmt.Errorf("PlantCrop: %w", err)
+	}
+	if _, err := contract.SubmitTransaction("BeginPayloadUpload", recordID); err != nil {
+		return result, fmt.Errorf("BeginPayloadUpload: %w", err)
+	}
+
+	started := time.Now()
+	latencies, err := uploadChunks(contract, recordID, chunks, concurrency)
+	result.upload = time.Since(started)
+	result.transactions += len(chunks)
+	result.latencyTotal += latencies
+	if err != nil {
+		return result, err
+	}
+
+	started = time.Now()
+	_, err = contract.SubmitTransaction("FinalizePayloadUpload", recordID, strconv.Itoa(len(chunks)), hex.EncodeToString(digest[:]))
+	result.finalize = time.Since(started)
+	result.transactions++
+	result.latencyTotal += result.finalize
+	if err != nil {
+		return result, fmt.Errorf("FinalizePayloadUpload: %w", err)
+	}
+
+	started = time.Now()
+	read, err := readBack(contract, recordID, len(chunks))
+	result.read = time.Since(started)
+	if err != nil {
+		return result, err
+	}
+	if !bytes.Equal(read, payload) {
+		return result, fmt.Errorf("payload read back for %s does not match the upload", recordID)
+	}
+
+	return result, nil
+}
+
+func uploadChunks(contract *client.Contract, recordID string, chunks [][]byte, concurrency int) (time.Duration, error) {
+	var (
+		mu        sync.Mutex
+		latencies time.Duration
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	indexes := make(chan int)
+
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				started := time.Now()
+				_, err := contract.SubmitTransaction("UploadPayloadChunk", recordID, strconv.Itoa(index), base64.StdEncoding.EncodeToString(chunks[index]))
+				elapsed := time.Since(started)
+
+				mu.Lock()
+				latencies += elapsed
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("UploadPayloadChunk %d: %w", index, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for index := range chunks {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return latencies, firstErr
+}
+
+// readBack streams the payload chunk by chunk so large sizes stay under the gRPC
+// response limit; the chaincode verifies every chunk against the manifest.
+func readBack(contract *client.Contract, recordID string, chunkCount int) ([]byte, error) {
+	var payload bytes.Buffer
+	for index := 0; index < chunkCount; index++ {
+		encoded, err := contract.EvaluateTransaction("ReadPayloadChunk", recordID, strconv.Itoa(index))
+		if err != nil {
+			return nil, fmt.Errorf("ReadPayloadChunk %d: %w", index, err)
+		}
+		data, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			return nil, err
+		}
+		payload.Write(data)
+	}
+	return payload.Bytes(), nil
+}
+
+func parseSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		size, err := parseSize(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+func parseSize(text string) (int, error) {
+	multiplier := 1
+	for _, unit := range []struct {
+		suffix string
+		factor int
+	}{{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}} {
+		if strings.HasSuffix(text, unit.suffix) {
+			multiplier = unit.factor
+			text = strings.TrimSuffix(text, unit.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(text)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return n * multiplier, nil
+}
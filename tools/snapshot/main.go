@@ -0,0 +1,180 @@
+// Command snapshot exports the primary records of a domain chaincode (crop records, or
+// crop balances for defi) to JSONL with a hash manifest, and imports such a snapshot
+// into a fresh network through the chaincode's admin-only BulkLoad function, so every
+// benchmark run can start from the same data.
+//
+//	snapshot export -domain monitoring -out monitoring-seed
+//	snapshot import -domain monitoring -in monitoring-seed
+//
+// Export writes <name>.jsonl and <name>.manifest.json; import refuses a snapshot whose
+// file or line hashes do not match its manifest and, unless -verify=false, re-exports
+// the state afterwards to check that the ledger now holds exactly the snapshot.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"agri-blockchain-benchmark/tools/internal/gateway"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	var err error
+	switch command {
+	case "export":
+		err = runExport(args)
+	case "import":
+		err = runImport(args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: snapshot export|import -domain <%s> [flags]\n", strings.Join(domainNames(), "|"))
+	os.Exit(2)
+}
+
+type commonFlags struct {
+	cfg           gateway.Config
+	domain        *string
+	channelName   *string
+	chaincodeName *string
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *commonFlags) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	common := &commonFlags{
+		domain:        fs.String("domain", "", "domain to snapshot: "+strings.Join(domainNames(), ", ")),
+		channelName:   fs.String("channel", "mychannel", "channel name"),
+		chaincodeName: fs.String("chaincode", "", "chaincode name, defaults to the domain name"),
+	}
+	common.cfg.RegisterFlags(fs)
+	return fs, common
+}
+
+// contract resolves the domain and connects to its chaincode. The caller closes the
+// returned function once done.
+func (c *commonFlags) contract() (domain, *client.Contract, func(), error) {
+	d, ok := domains[*c.domain]
+	if !ok {
+		return domain{}, nil, nil, fmt.Errorf("unknown domain %q, expected one of %s", *c.domain, strings.Join(domainNames(), ", "))
+	}
+	chaincodeName := *c.chaincodeName
+	if chaincodeName == "" {
+		chaincodeName = d.name
+	}
+
+	gw, conn, err := gateway.Connect(c.cfg)
+	if err != nil {
+		return domain{}, nil, nil, fmt.Errorf("failed to connect to gateway: %w", err)
+	}
+	closeAll := func() {
+		gw.Close()
+		conn.Close()
+	}
+	return d, gw.GetNetwork(*c.channelName).GetContract(chaincodeName), closeAll, nil
+}
+
+func runExport(args []string) error {
+	fs, common := newFlagSet("export")
+	out := fs.String("out", "", "output name; writes <out>.jsonl and <out>.manifest.json")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	d, contract, closeAll, err := common.contract()
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+
+	lines, err := exportLines(contract, d)
+	if err != nil {
+		return err
+	}
+	m, err := writeSnapshot(*out, d, lines)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("exported %d %s records to %s.jsonl (sha256 %s)", m.Records, d.name, *out, m.SHA256)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs, common := newFlagSet("import")
+	in := fs.String("in", "", "snapshot name; reads <in>.jsonl and <in>.manifest.json")
+	batchSize := fs.Int("batch", 100, "records per BulkLoad transaction")
+	verify := fs.Bool("verify", true, "re-export after importing and compare with the manifest")
+	fs.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("-batch must be positive")
+	}
+
+	m, lines, err := readSnapshot(*in)
+	if err != nil {
+		return err
+	}
+	if *common.domain == "" {
+		*common.domain = m.Domain
+	}
+	if *common.domain != m.Domain {
+		return fmt.Errorf("snapshot %s was exported from %s, not %s", *in, m.Domain, *common.domain)
+	}
+
+	d, contract, closeAll, err := common.contract()
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+
+	for start := 0; start < len(lines); start += *batchSize {
+		end := start + *batchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		batch := "[" + strings.Join(lines[start:end], ",") + "]"
+		if _, err := contract.SubmitTransaction("BulkLoad", batch); err != nil {
+			return fmt.Errorf("BulkLoad of records %d to %d: %w", start, end-1, err)
+		}
+		log.Printf("loaded %d/%d records", end, len(lines))
+	}
+
+	if !*verify {
+		return nil
+	}
+
+	exported, err := exportLines(contract, d)
+	if err != nil {
+		return fmt.Errorf("verification export: %w", err)
+	}
+	want := append([]string(nil), m.Lines...)
+	got := lineHashes(exported)
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		return fmt.Errorf("ledger holds %d %s records after import, which do not match the %d in the snapshot", len(got), d.name, len(want))
+	}
+
+	log.Printf("verified %d %s records against %s.manifest.json", len(got), d.name, *in)
+	return nil
+}
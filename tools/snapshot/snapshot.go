@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// domain describes how to read the primary records of one chaincode: the query that
+// returns every record of that type, tombstoned ones included, and the JSON field
+// holding the record key. Secondary state such as documents, payloads, attestations,
+// grants, loans, tokens and orders is not exported.
+type domain struct {
+	name          string
+	queryFunction string
+	keyField      string
+}
+
+var domains = map[string]domain{
+	"supplyChain": {"supplyChain", "GetAllCrops", "cropID"},
+	"monitoring":  {"monitoring", "GetAllCropRecordsIncludingDeleted", "id"},
+	"dataStorage": {"dataStorage", "GetAllCropsIncludingDeleted", "id"},
+	"defi":        {"defi", "GetAllCropBalances", "farmer"},
+}
+
+func domainNames() []string {
+	names := make([]string, 0, len(domains))
+	for name := range domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type manifest struct {
+	Domain        string    `json:"domain"`
+	QueryFunction string    `json:"queryFunction"`
+	ExportedAt    time.Time `json:"exportedAt"`
+	Records       int       `json:"records"`
+	SHA256        string    `json:"sha256"`
+	Lines         []string  `json:"lines"`
+}
+
+// exportLines returns one compact JSON document per record, sorted by record key so
+// that two exports of the same state are byte-for-byte identical.
+func exportLines(contract *client.Contract, d domain) ([]string, error) {
+	result, err := contract.EvaluateTransaction(d.queryFunction)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", d.queryFunction, err)
+	}
+
+	var records []json.RawMessage
+	if len(bytes.TrimSpace(result)) > 0 {
+		if err := json.Unmarshal(result, &records); err != nil {
+			return nil, fmt.Errorf("%s returned an unexpected response: %w", d.queryFunction, err)
+		}
+	}
+
+	type keyed struct {
+		key  string
+		line string
+	}
+	rows := make([]keyed, 0, len(records))
+	for _, record := range records {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(record, &fields); err != nil {
+			return nil, err
+		}
+		var key string
+		if err := json.Unmarshal(fields[d.keyField], &key); err != nil || key == "" {
+			return nil, fmt.Errorf("%s record without a %q key: %s", d.name, d.keyField, record)
+		}
+
+		var line bytes.Buffer
+		if err := json.Compact(&line, record); err != nil {
+			return nil, err
+		}
+		rows = append(rows, keyed{key, line.String()})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key < rows[j].key })
+
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = row.line
+	}
+	return lines, nil
+}
+
+func lineHashes(lines []string) []string {
+	hashes := make([]string, len(lines))
+	for i, line := range lines {
+		sum := sha256.Sum256([]byte(line))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+	return hashes
+}
+
+func writeSnapshot(name string, d domain, lines []string) (*manifest, error) {
+	var data bytes.Buffer
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteByte('\n')
+	}
+	sum := sha256.Sum256(data.Bytes())
+
+	m := &manifest{
+		Domain:        d.name,
+		QueryFunction: d.queryFunction,
+		ExportedAt:    time.Now().UTC(),
+		Records:       len(lines),
+		SHA256:        hex.EncodeToString(sum[:]),
+		Lines:         lineHashes(lines),
+	}
+	manifestJSON, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(name+".jsonl", data.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(name+".manifest.json", append(manifestJSON, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readSnapshot loads a snapshot and checks it against its manifest before anything is
+// submitted, so a truncated or edited file never reaches the ledger.
+func readSnapshot(name string) (*manifest, []string, error) {
+	manifestJSON, err := os.ReadFile(name + ".manifest.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(manifestJSON, &m); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if _, ok := domains[m.Domain]; !ok {
+		return nil, nil, fmt.Errorf("manifest names unknown domain %q", m.Domain)
+	}
+
+	data, err := os.ReadFile(name + ".jsonl")
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); actual != m.SHA256 {
+		return nil, nil, fmt.Errorf("%s.jsonl hashes to %s, manifest expects %s", name, actual, m.SHA256)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(lines) != m.Records || len(m.Lines) != m.Records {
+		return nil, nil, fmt.Errorf("%s.jsonl has %d records, manifest lists %d hashes for %d records", name, len(lines), len(m.Lines), m.Records)
+	}
+	for i, hash := range lineHashes(lines) {
+		if hash != m.Lines[i] {
+			return nil, nil, fmt.Errorf("record %d of %s.jsonl does not match its manifest hash", i+1, name)
+		}
+	}
+
+	return &m, lines, nil
+}
@@ -0,0 +1,207 @@
+// Command weather-oracle reads weather observations from a local file or an HTTP
+// stand-in, signs each one with the oracle's key and submits it to the monitoring
+// chaincode on a fixed schedule.
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+
+	"agri-blockchain-benchmark/tools/internal/gateway"
+)
+
+func main() {
+	var (
+		cfg           gateway.Config
+		source        = flag.String("source", "observations.jsonl", "observation file path or http(s) URL")
+		interval      = flag.Duration("interval", time.Minute, "how often to poll the source")
+		once          = flag.Bool("once", false, "submit one batch and exit")
+		oracleID      = flag.String("oracle-id", "", "oracle ID registered in the monitoring chaincode")
+		oracleKey     = flag.String("oracle-key", "", "PEM private key of the oracle (PKCS#8 or SEC 1)")
+		channelName   = flag.String("channel", "mychannel", "channel name")
+		chaincodeName = flag.String("chaincode", "monitoring", "monitoring chaincode name")
+	)
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *oracleID == "" || *oracleKey == "" {
+		log.Fatal("-oracle-id and -oracle-key are required")
+	}
+
+	signer, err := loadOracleSigner(*oracleKey)
+	if err != nil {
+		log.Fatalf("failed to load oracle key: %v", err)
+	}
+
+	gw, conn, err := gateway.Connect(cfg)
+	if err != nil {
+		log.Fatalf("failed to connect to gateway: %v", err)
+	}
+	defer conn.Close()
+	defer gw.Close()
+
+	contract := gw.GetNetwork(*channelName).GetContract(*chaincodeName)
+	o := &oracle{
+		id:       *oracleID,
+		signer:   signer,
+		source:   newSource(*source),
+		contract: contract,
+		seen:     make(map[string]bool),
+		loaded:   make(map[string]bool),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		o.poll(ctx)
+		if *once {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// oracle tracks which observations are already on the ledger in seen, keyed by
+// location and time. loaded records the locations whose ledger history has been read
+// into seen, so that a restarted oracle does not resubmit what it reported before.
+type oracle struct {
+	id       string
+	signer   crypto.Signer
+	source   Source
+	contract *client.Contract
+	seen     map[string]bool
+	loaded   map[string]bool
+}
+
+func (o *oracle) poll(ctx context.Context) {
+	observations, err := o.source.Fetch(ctx)
+	if err != nil {
+		log.Printf("failed to fetch observations: %v", err)
+		return
+	}
+
+	submitted := 0
+	for _, observation := range observations {
+		observation.OracleID = o.id
+		if err := o.loadSeen(observation.Location); err != nil {
+			log.Printf("failed to read observations for %s from the ledger: %v", observation.Location, err)
+			continue
+		}
+		seenKey := observationKey(observation)
+		if o.seen[seenKey] {
+			continue
+		}
+
+		if err := o.submit(observation); err != nil {
+			log.Printf("failed to submit observation for %s at %s: %v", observation.Location, observation.ObservedAt, err)
+			continue
+		}
+		o.seen[seenKey] = true
+		submitted++
+	}
+	log.Printf("submitted %d of %d observations", submitted, len(observations))
+}
+
+// loadSeen marks the observations this oracle already submitted for a location as seen,
+// reading them from the ledger the first time the location comes up.
+func (o *oracle) loadSeen(location string) error {
+	if o.loaded[location] {
+		return nil
+	}
+	result, err := o.contract.EvaluateTransaction("GetWeatherObservations", location, "", "")
+	if err != nil {
+		return err
+	}
+
+	var recorded []struct {
+		Observation Observation `json:"observation"`
+	}
+	if len(result) > 0 {
+		if err := json.Unmarshal(result, &recorded); err != nil {
+			return fmt.Errorf("failed to decode observations: %w", err)
+		}
+	}
+	for _, r := range recorded {
+		if r.Observation.OracleID == o.id {
+			o.seen[observationKey(r.Observation)] = true
+		}
+	}
+	o.loaded[location] = true
+	return nil
+}
+
+func observationKey(observation Observation) string {
+	return observation.Location + "|" + observation.ObservedAt.UTC().Format(time.RFC3339Nano)
+}
+
+func (o *oracle) submit(observation Observation) error {
+	payload, err := json.Marshal(observation)
+	if err != nil {
+		return err
+	}
+	signature, err := sign(o.signer, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.contract.SubmitTransaction("SubmitWeatherObservation", o.id, string(payload), base64.StdEncoding.EncodeToString(signature))
+	return err
+}
+
+// sign produces the signature format the chaincode verifies: ASN.1 ECDSA over the
+// SHA-256 digest, or a plain Ed25519 signature over the payload.
+func sign(signer crypto.Signer, payload []byte) ([]byte, error) {
+	switch signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(payload)
+		return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case ed25519.PrivateKey:
+		return signer.Sign(rand.Reader, payload, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported oracle key type %T", signer)
+	}
+}
+
+func loadOracleSigner(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported oracle key type %T", key)
+		}
+		return signer, nil
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
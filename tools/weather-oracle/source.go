@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Observation mirrors WeatherObservation in the monitoring chaincode. Field names must
+// stay in sync because the chaincode parses the signed payload with its own struct.
+type Observation struct {
+	OracleID     string    `json:"oracleId"`
+	Location     string    `json:"location"`
+	ObservedAt   time.Time `json:"observedAt"`
+	RainfallMM   float64   `json:"rainfallMm"`
+	TemperatureC float64   `json:"temperatureC"`
+	WindSpeedMS  float64   `json:"windSpeedMs"`
+}
+
+type Source interface {
+	Fetch(ctx context.Context) ([]Observation, error)
+}
+
+func newSource(location string) Source {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &httpSource{url: location, client: &http.Client{Timeout: 30 * time.Second}}
+	}
+	return &fileSource{path: location}
+}
+
+// fileSource reads either a JSON array or one JSON object per line.
+type fileSource struct {
+	path string
+}
+
+func (f *fileSource) Fetch(ctx context.Context) ([]Observation, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeObservations(data)
+}
+
+// httpSource polls an endpoint that stands in for a weather provider and answers
+// with the same formats fileSource accepts.
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpSource) Fetch(ctx context.Context) ([]Observation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", h.url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeObservations(data)
+}
+
+func decodeObservations(data []byte) ([]Observation, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var observations []Observation
+	if data[0] == '[' {
+		if err := json.Unmarshal(data, &observations); err != nil {
+			return nil, fmt.Errorf("failed to parse observations: %w", err)
+		}
+		return observations, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var observation Observation
+		if err := json.Unmarshal(text, &observation); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		observations = append(observations, observation)
+	}
+	return observations, scanner.Err()
+}